@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	mw "restapi/internal/api/middlewares"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// durationEnv reads a time.ParseDuration value such as "72h" from the
+// environment, falling back to def when the variable is unset.
+func durationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", name, value, err)
+	}
+	return d
+}
+
+// intEnv reads an integer from the environment, falling back to def when the
+// variable is unset.
+func intEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", name, value, err)
+	}
+	return n
+}
+
+// listEnv reads a comma separated list from the environment.
+func listEnv(name string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// boolEnv reads a strconv.ParseBool value from the environment, falling back
+// to def when the variable is unset.
+func boolEnv(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", name, value, err)
+	}
+	return b
+}
+
+// corsOptionsFromEnv reads the CORS policy, starting from
+// mw.DefaultCorsOptions:
+//
+//	CORS_ALLOWED_ORIGINS         e.g. "https://app.example.com,https://*.example.com"
+//	CORS_ALLOWED_ORIGIN_PATTERNS regular expressions, comma separated
+//	CORS_ALLOWED_METHODS         e.g. "GET,POST"
+//	CORS_ALLOWED_HEADERS         e.g. "Content-Type,X-API-Key" or "*"
+//	CORS_EXPOSED_HEADERS         e.g. "ETag,Location"
+//	CORS_ALLOW_CREDENTIALS       true or false
+//	CORS_MAX_AGE                 preflight cache duration, e.g. "10m"
+//
+// Allowing the origin "*" requires CORS_ALLOW_CREDENTIALS=false.
+func corsOptionsFromEnv() mw.CorsOptions {
+	options := mw.DefaultCorsOptions
+	if list := listEnv("CORS_ALLOWED_ORIGINS"); list != nil {
+		options.AllowedOrigins = list
+	}
+	options.AllowedOriginPatterns = listEnv("CORS_ALLOWED_ORIGIN_PATTERNS")
+	if list := listEnv("CORS_ALLOWED_METHODS"); list != nil {
+		options.AllowedMethods = list
+	}
+	if list := listEnv("CORS_ALLOWED_HEADERS"); list != nil {
+		options.AllowedHeaders = list
+	}
+	if list := listEnv("CORS_EXPOSED_HEADERS"); list != nil {
+		options.ExposedHeaders = list
+	}
+	options.AllowCredentials = boolEnv("CORS_ALLOW_CREDENTIALS", options.AllowCredentials)
+	options.MaxAge = durationEnv("CORS_MAX_AGE", options.MaxAge)
+	return options
+}
+
+// accessLogOptionsFromEnv reads the access log configuration:
+//
+//	ACCESS_LOG_FORMAT   text, json, common or combined (text)
+//	ACCESS_LOG_EXCLUDE  path prefixes not logged, e.g. "/health,/csp-report"
+//	ACCESS_LOG_SAMPLE   fraction logged per path prefix, e.g. "/teachers=0.1"
+func accessLogOptionsFromEnv() (mw.AccessLogOptions, error) {
+	options := mw.AccessLogOptions{
+		Format:             os.Getenv("ACCESS_LOG_FORMAT"),
+		Exclude:            listEnv("ACCESS_LOG_EXCLUDE"),
+		Sample:             make(map[string]float64),
+		ResponseTimeHeader: "X-Response-Time",
+	}
+	for _, entry := range listEnv("ACCESS_LOG_SAMPLE") {
+		prefix, rate, ok := strings.Cut(entry, "=")
+		f, err := strconv.ParseFloat(rate, 64)
+		if !ok || err != nil || f < 0 || f > 1 {
+			return options, fmt.Errorf("invalid ACCESS_LOG_SAMPLE entry %q, expected PREFIX=FRACTION", entry)
+		}
+		options.Sample[strings.TrimSpace(prefix)] = f
+	}
+	return options, nil
+}
+
+// redisFromEnv connects to the Redis at REDIS_URL, e.g.
+// "redis://localhost:6379/0", which shares rate limits and quotas between
+// replicas. It returns nil when REDIS_URL is unset.
+func redisFromEnv() (*redis.Client, error) {
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		return nil, nil
+	}
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	return redis.NewClient(options), nil
+}
+
+// rateLimitOptionsFromEnv reads the rate limiter configuration:
+//
+//	RATE_LIMIT_ALGORITHM  token_bucket, leaky_bucket, fixed_window,
+//	                      sliding_window_log or sliding_window_counter
+//	RATE_LIMIT            requests per window (100)
+//	RATE_LIMIT_WINDOW     window length (1m)
+//	RATE_LIMIT_BURST      bucket capacity (RATE_LIMIT)
+//	RATE_LIMIT_KEY        ip, user, apikey or route, combined with "+"
+//	RATE_LIMIT_ROUTES     per route overrides, e.g.
+//	                      "POST /execs/login=5/1m, GET /teachers/export=10/1h"
+//	RATE_LIMIT_FAILURE_POLICY
+//	                      open (use local limits) or closed (reject) while
+//	                      Redis is unreachable
+//
+// The limits are shared through rdb when it is not nil.
+func rateLimitOptionsFromEnv(mux *http.ServeMux, rdb *redis.Client) (mw.RateLimitOptions, error) {
+	options := mw.RateLimitOptions{
+		Algorithm:     os.Getenv("RATE_LIMIT_ALGORITHM"),
+		Limit:         intEnv("RATE_LIMIT", 100),
+		Window:        durationEnv("RATE_LIMIT_WINDOW", time.Minute),
+		Burst:         intEnv("RATE_LIMIT_BURST", 0),
+		FailurePolicy: os.Getenv("RATE_LIMIT_FAILURE_POLICY"),
+	}
+
+	if rdb != nil {
+		options.Store = mw.NewRedisStore(rdb)
+	}
+
+	if name := os.Getenv("RATE_LIMIT_KEY"); name != "" {
+		var keys []mw.KeyFunc
+		for _, part := range strings.Split(name, "+") {
+			switch strings.TrimSpace(part) {
+			case "ip":
+				keys = append(keys, mw.KeyByClientIP)
+			case "user":
+				keys = append(keys, mw.KeyByUser)
+			case "apikey":
+				keys = append(keys, mw.KeyByAPIKey)
+			case "route":
+				keys = append(keys, mw.KeyByRoute(mux))
+			default:
+				return options, fmt.Errorf("unknown RATE_LIMIT_KEY %q", part)
+			}
+		}
+		options.Key = mw.CompositeKey(keys...)
+		if len(keys) == 1 {
+			options.Key = keys[0]
+		}
+	}
+
+	options.Routes = make(map[string]mw.RateLimitOptions)
+	for _, route := range listEnv("RATE_LIMIT_ROUTES") {
+		pattern, limit, ok := strings.Cut(route, "=")
+		count, window, ok2 := strings.Cut(limit, "/")
+		if !ok || !ok2 {
+			return options, fmt.Errorf("invalid RATE_LIMIT_ROUTES entry %q, expected PATTERN=LIMIT/WINDOW", route)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return options, fmt.Errorf("invalid limit in RATE_LIMIT_ROUTES entry %q: %w", route, err)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return options, fmt.Errorf("invalid window in RATE_LIMIT_ROUTES entry %q: %w", route, err)
+		}
+		options.Routes[strings.TrimSpace(pattern)] = mw.RateLimitOptions{Limit: n, Window: d}
+	}
+	return options, nil
+}
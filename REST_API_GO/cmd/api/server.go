@@ -2,213 +2,361 @@ package main
 
 import (
 	"crypto/tls"
-	"encoding/json"
+	"expvar"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"restapi/internal/api/handlers"
 	mw "restapi/internal/api/middlewares"
-	"strconv"
-	"strings"
-	"sync"
+	"restapi/internal/audit"
+	"restapi/internal/models"
+	"restapi/internal/quota"
+	"restapi/internal/reports"
+	"restapi/internal/repository"
+	"restapi/internal/seed"
+	"syscall"
+	"time"
 )
 
-type Teacher struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Class     string
-	Subject   string
+func rootHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := w.Write([]byte("Hello World"))
+	if err != nil {
+		return
+	}
+	fmt.Println("Hello World Route")
 }
 
-var (
-	teachers = make(map[int]Teacher)
-	mutex    = &sync.Mutex{}
-	nextID   = 1
-)
-
-func init() {
-	teachers[nextID] = Teacher{
-		ID:        nextID,
-		FirstName: "John",
-		LastName:  "Doe",
-		Class:     "9A",
-		Subject:   "Math",
-	}
-	nextID++
-	teachers[nextID] = Teacher{
-		ID:        nextID,
-		FirstName: "Jane",
-		LastName:  "Smith",
-		Class:     "10A",
-		Subject:   "Algebra",
+func ExcesHandler(w http.ResponseWriter, r *http.Request) {
+	_, err := w.Write([]byte("Exces"))
+	if err != nil {
+		return
 	}
+	fmt.Println("Exces Route")
 }
 
-func getTeachersHandler(w http.ResponseWriter, r *http.Request) {
-
-	path := strings.TrimPrefix(r.URL.Path, "/teachers/")
-	idStr := strings.TrimPrefix(path, "/")
-	fmt.Println(idStr)
-
-	if idStr != "" {
-		firstName := r.URL.Query().Get("firstName")
-		lastName := r.URL.Query().Get("lastName")
-
-		teacherList := make([]Teacher, 0, len(teachers))
-		for _, teacher := range teachers {
-			if firstName != "" && teacher.FirstName == firstName && (lastName == "" || teacher.LastName == lastName) {
-				teacherList = append(teacherList, teacher)
+// reloadOnHangup re-reads the IP rules whenever the process gets SIGHUP,
+// e.g. after "kill -HUP <pid>".
+func reloadOnHangup(ipFilter *mw.IPFilter) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := ipFilter.Reload(); err != nil {
+				slog.Error("Error reloading IP rules, keeping the previous ones", "err", err)
 				continue
 			}
-			teacherList = append(teacherList, teacher)
-		}
-		response := struct {
-			Status string    `json:"status"`
-			Count  int       `json:"count"`
-			Data   []Teacher `json:"data"`
-		}{
-			Status: "success",
-			Count:  len(teacherList),
-			Data:   teacherList,
+			slog.Info("Reloaded IP rules")
 		}
-		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(response)
+	}()
+}
+
+func main() {
+	port := ":3000"
+
+	cert := "cert.pem"
+	key := "key.pem"
+
+	// Log lines written with a request's context carry its request ID.
+	slog.SetDefault(slog.New(mw.LogHandler(slog.NewTextHandler(os.Stderr, nil))))
+
+	mux := http.NewServeMux()
+
+	storeConfig := repository.ConfigFromEnv()
+	teachers, err := repository.Open[models.Teacher](storeConfig, "teachers")
+	if err != nil {
+		log.Fatal("Error opening teachers store: ", err)
+	}
+	students, err := repository.Open[models.Student](storeConfig, "students")
+	if err != nil {
+		log.Fatal("Error opening students store: ", err)
+	}
+	// The memory backend starts empty, so give it the demo data unless told
+	// otherwise. Persistent backends are seeded with cmd/seed.
+	dataset, ok := os.LookupEnv("SEED_DATASET")
+	if !ok && storeConfig.Backend == repository.BackendMemory {
+		dataset = "demo"
+	}
+	if dataset != "" {
+		ds, err := seed.Named(dataset, 100)
 		if err != nil {
-			return
+			log.Fatal("Error loading seed data: ", err)
+		}
+		if _, err := seed.Apply(ds, teachers, students); err != nil {
+			log.Fatal("Error seeding stores: ", err)
 		}
 	}
 
-	// Handle Path parameters
-	id, err := strconv.Atoi(idStr)
+	auditLog, err := audit.Open(os.Getenv("AUDIT_LOG"))
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
-		return
+		log.Fatal("Error opening audit log: ", err)
 	}
+	defer auditLog.Close()
 
-	teacher, exists := teachers[id]
-	if !exists {
-		http.Error(w, "Teacher not found", http.StatusNotFound)
-		return
+	reportStore, err := reports.Open(os.Getenv("CSP_REPORTS_FILE"), time.Minute)
+	if err != nil {
+		log.Fatal("Error opening violation reports: ", err)
 	}
-
-	err = json.NewEncoder(w).Encode(teacher)
+	defer reportStore.Close()
+	// Browsers report every violation on every page load; a client that
+	// sends more than this is misbehaving.
+	reportLimiter, err := mw.NewLimiter(mw.RateLimitOptions{Limit: 60, Window: time.Minute})
 	if err != nil {
-		return
+		log.Fatal("Error configuring report limiter: ", err)
 	}
-}
 
-func rootHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := w.Write([]byte("Hello World"))
+	rdb, err := redisFromEnv()
 	if err != nil {
-		return
+		log.Fatal("Error configuring Redis: ", err)
 	}
-	fmt.Println("Hello World Route")
-}
-
-func teachersHandler(w http.ResponseWriter, r *http.Request) {
 
-	fmt.Println(r.Method)
-	switch r.Method {
-	case http.MethodGet:
-		getTeachersHandler(w, r)
-	case http.MethodPost:
-		_, err := w.Write([]byte("Hello POST Method on Teachers Route"))
+	// API keys are optional: without a keys file every request is anonymous
+	// and no quotas apply.
+	var apiKeys *mw.APIKeys
+	var usage quota.Store
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		apiKeys, err = mw.LoadAPIKeys(path)
 		if err != nil {
-			return
+			log.Fatal("Error loading API keys: ", err)
 		}
-		fmt.Println("Hello POST Method on Teachers Route")
-	case http.MethodPut:
-		_, err := w.Write([]byte("Hello PUT Method on Teachers Route"))
-		if err != nil {
-			return
-		}
-		fmt.Println("Hello PUT Method on Teachers Route")
-	case http.MethodPatch:
-		_, err := w.Write([]byte("Hello PATCH Method on Teachers Route"))
-		if err != nil {
-			return
-		}
-		fmt.Println("Hello PATCH Method on Teachers Route")
-	case http.MethodDelete:
-		_, err := w.Write([]byte("Hello DELETE Method on Teachers Route"))
-		if err != nil {
-			return
+		if rdb != nil {
+			usage = quota.NewRedisStore(rdb)
+		} else {
+			usage, err = quota.NewMemoryStore(os.Getenv("USAGE_FILE"), durationEnv("USAGE_FLUSH_INTERVAL", time.Minute))
+			if err != nil {
+				log.Fatal("Error loading usage counters: ", err)
+			}
 		}
-		fmt.Println("Hello DELETE Method on Teachers Route")
-
 	}
 
-	_, err := w.Write([]byte("Teachers"))
+	// Only the proxies listed here may tell us the client address through
+	// X-Forwarded-For or Forwarded.
+	trustedProxies, err := mw.ParseTrustedProxies(listEnv("TRUSTED_PROXIES"))
 	if err != nil {
-		return
+		log.Fatal("Error configuring trusted proxies: ", err)
+	}
+	rateLimitOptions, err := rateLimitOptionsFromEnv(mux, rdb)
+	if err != nil {
+		log.Fatal("Error configuring rate limiter: ", err)
+	}
+	rl, err := mw.NewRateLimitter(rateLimitOptions)
+	if err != nil {
+		log.Fatal("Error configuring rate limiter: ", err)
+	}
+	hppOptions := mw.HTTPOptions{
+		CheckQuery: true,
+		CheckBody:  true,
+		// Column mappings for imports are meant to be repeated.
+		Duplicates: map[string]string{"map": mw.DuplicateArray},
+		Routes: map[string]mw.HTTPOptions{
+			"GET /teachers": {QueryWhitelist: []string{"firstName", "lastName"}},
+			"GET /students": {QueryWhitelist: []string{"firstName", "lastName", "class"}},
+		},
+	}
+	// Handlers get a deadline and bodies a size cap. Imports need more of
+	// both, and exports stream for as long as they take.
+	timeoutOptions := mw.TimeoutOptions{
+		Timeout: durationEnv("HANDLER_TIMEOUT", 15*time.Second),
+		Status:  intEnv("HANDLER_TIMEOUT_STATUS", http.StatusServiceUnavailable),
+		Routes: map[string]mw.TimeoutOptions{
+			"POST /teachers/import": {Timeout: durationEnv("IMPORT_TIMEOUT", 45*time.Second)},
+			"POST /students/import": {Timeout: durationEnv("IMPORT_TIMEOUT", 45*time.Second)},
+			"GET /teachers/export":  {},
+			"GET /students/export":  {},
+		},
+	}
+	bodyLimitOptions := mw.BodyLimitOptions{
+		MaxBytes: int64(intEnv("MAX_BODY_SIZE", 1<<20)),
+		Routes: map[string]int64{
+			"POST /teachers/import": int64(intEnv("MAX_IMPORT_SIZE", 10<<20)),
+			"POST /students/import": int64(intEnv("MAX_IMPORT_SIZE", 10<<20)),
+		},
+	}
+	// HPP checks every body the default limit allows, and lets the larger
+	// ones of the routes allowed more through unchecked.
+	hppOptions.MaxBodySize = bodyLimitOptions.MaxBytes
+	// The body limits hold for decoded bodies too; the body limit middleware
+	// has already capped them as sent.
+	decompressionOptions := mw.DecompressionOptions{
+		MaxDecompressedSize: bodyLimitOptions.MaxBytes,
+		Routes:              bodyLimitOptions.Routes,
+	}
+	// Only the public reads are cached; everything behind an API key may
+	// differ per key.
+	cache := mw.NewCache(mw.CacheOptions{
+		Routes:   []string{"GET /teachers", "GET /teachers/{id}", "GET /students", "GET /students/{id}"},
+		MaxBytes: int64(intEnv("CACHE_MAX_SIZE", 32<<20)),
+		TTL:      durationEnv("CACHE_TTL", 30*time.Second),
+	})
+	// Retried POSTs and PATCHes with the same Idempotency-Key get the first
+	// response back instead of running again.
+	idempotencyOptions := mw.IdempotencyOptions{
+		TTL:         durationEnv("IDEMPOTENCY_TTL", 24*time.Hour),
+		LockTimeout: durationEnv("IDEMPOTENCY_LOCK_TIMEOUT", time.Minute),
+	}
+	if rdb != nil {
+		idempotencyOptions.Store = mw.NewRedisIdempotencyStore(rdb)
+	}
+	cors, err := mw.CorsWithOptions(corsOptionsFromEnv())
+	if err != nil {
+		log.Fatal("Error configuring CORS: ", err)
+	}
+	accessLogOptions, err := accessLogOptionsFromEnv()
+	if err != nil {
+		log.Fatal("Error configuring access log: ", err)
+	}
+	accessLog, err := mw.AccessLog(accessLogOptions)
+	if err != nil {
+		log.Fatal("Error configuring access log: ", err)
 	}
 
-}
+	// The IP filter sits in the global chain but judges each request by its
+	// route's group, which the router learns as routes are registered below.
+	var router *mw.Router
+	var ipFilter *mw.IPFilter
+	if path := os.Getenv("IP_RULES_FILE"); path != "" {
+		ipFilter, err = mw.LoadIPFilter(path)
+		if err != nil {
+			log.Fatal("Error loading IP rules: ", err)
+		}
+		reloadOnHangup(ipFilter)
+	}
 
-func studentsHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := w.Write([]byte("Students"))
-	if err != nil {
-		return
+	// Every request runs these, in this order. Networks are checked before
+	// credentials, so a stolen key is no use outside the networks its routes
+	// accept and rejected requests cost no rate limit or quota. Authenticate
+	// before rate limiting so limits can be keyed by user; requests with an
+	// unknown key never reach the rate limiter, so authenticate throttles
+	// them itself, per client IP. Quotas are counted in the route groups,
+	// once every check that could reject a request has passed. The timeout
+	// runs handlers on their own goroutine, so it comes after the
+	// middlewares that tell the access log who the user is.
+	global := mw.NewChain().
+		Use("real-ip", mw.RealIP(trustedProxies)).
+		Use("request-id", mw.RequestIDs).
+		Use("access-log", accessLog).
+		Use("recover", mw.Recover).
+		Use("cors", cors).
+		Use("security-headers", mw.SecurityHeaders).
+		Use("compression", mw.Compression)
+	if ipFilter != nil {
+		global = global.Use("ip-filter", ipFilter.Middleware(func(r *http.Request) string {
+			return router.GroupOf(r)
+		}))
 	}
-	fmt.Println("Students Route")
-}
+	if apiKeys != nil {
+		global = global.Use("authenticate", mw.AuthenticateWithOptions(apiKeys, mw.AuthenticateOptions{
+			MaxFailures:   intEnv("AUTH_MAX_FAILURES", 10),
+			FailureWindow: durationEnv("AUTH_FAILURE_WINDOW", time.Minute),
+		}))
+	}
+	global = global.
+		Use("rate-limit", rl.Middleware).
+		Use("timeout", mw.Timeout(timeoutOptions)).
+		Use("body-limit", mw.BodyLimit(bodyLimitOptions)).
+		Use("decompression", mw.Decompression(decompressionOptions)).
+		Use("hpp", mw.Hpp(hppOptions)).
+		Use("cache-invalidation", cache.Invalidate)
 
-func ExcesHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := w.Write([]byte("Exces"))
-	if err != nil {
-		return
+	// Each group runs its access checks first, then the metered chain, so
+	// that requests the checks reject are neither billed nor stored for
+	// retries.
+	publicChecks, authenticatedChecks, adminChecks := mw.NewChain(), mw.NewChain(), mw.NewChain()
+	if apiKeys != nil {
+		authenticatedChecks = authenticatedChecks.Use("require-api-key", mw.RequireAPIKey)
+		adminChecks = adminChecks.Use("require-admin", mw.RequireRole(mw.RoleAdmin))
+	} else {
+		fmt.Println("API_KEYS_FILE is not set, every route is public")
 	}
-	fmt.Println("Exces Route")
-}
-func main() {
-	port := ":3000"
+	metered := mw.NewChain()
+	if usage != nil {
+		metered = metered.Use("quota", mw.Quota(usage, func(r *http.Request) string {
+			return router.Resource(r)
+		}))
+	}
+	writes := metered.Use("idempotency", mw.Idempotency(idempotencyOptions))
 
-	cert := "cert.pem"
-	key := "key.pem"
+	router = mw.NewRouter(mux, global)
+	public := router.Group("public", publicChecks.Extend(metered).Use("cache", cache.Middleware))
+	authenticated := router.Group("authenticated", authenticatedChecks.Extend(writes))
+	admin := router.Group("admin", adminChecks.Extend(writes))
+	ifMatch := router.Group("authenticated", authenticatedChecks.Use("require-if-match", mw.RequireIfMatch).Extend(writes))
 
-	mux := http.NewServeMux()
+	public.HandleFunc("/", rootHandler)
+
+	teachersHandler := handlers.NewTeachersHandler(teachers, auditLog)
+	public.HandleFunc("GET /teachers", teachersHandler.List)
+	public.HandleFunc("GET /teachers/{id}", teachersHandler.Get)
+	authenticated.HandleFunc("POST /teachers", teachersHandler.Create)
+	authenticated.HandleFunc("GET /teachers/export", teachersHandler.Export)
+	authenticated.HandleFunc("POST /teachers/import", teachersHandler.Import)
+	ifMatch.HandleFunc("PUT /teachers/{id}", teachersHandler.Update)
+	ifMatch.HandleFunc("PATCH /teachers/{id}", teachersHandler.Patch)
+	ifMatch.HandleFunc("DELETE /teachers/{id}", teachersHandler.Delete)
+	authenticated.HandleFunc("POST /teachers/{id}/restore", teachersHandler.Restore)
 
-	mux.HandleFunc("/", rootHandler)
-	mux.HandleFunc("/teachers/", teachersHandler)
+	studentsHandler := handlers.NewStudentsHandler(students, auditLog)
+	public.HandleFunc("GET /students", studentsHandler.List)
+	public.HandleFunc("GET /students/{id}", studentsHandler.Get)
+	authenticated.HandleFunc("POST /students", studentsHandler.Create)
+	authenticated.HandleFunc("GET /students/export", studentsHandler.Export)
+	authenticated.HandleFunc("POST /students/import", studentsHandler.Import)
+	ifMatch.HandleFunc("PUT /students/{id}", studentsHandler.Update)
+	ifMatch.HandleFunc("PATCH /students/{id}", studentsHandler.Patch)
+	ifMatch.HandleFunc("DELETE /students/{id}", studentsHandler.Delete)
+	authenticated.HandleFunc("POST /students/{id}/restore", studentsHandler.Restore)
 
-	mux.HandleFunc("/students/", studentsHandler)
+	// Deleted records stay restorable for the retention period. The handlers
+	// purge them, so every purged record is audited. Setting
+	// TRASH_PURGE_INTERVAL to 0 keeps them until they are restored.
+	retention := durationEnv("TRASH_RETENTION", 30*24*time.Hour)
+	purgeInterval := durationEnv("TRASH_PURGE_INTERVAL", time.Hour)
+	repository.SchedulePurge(retention, purgeInterval, map[string]repository.Purger{
+		"teachers": teachersHandler,
+		"students": studentsHandler,
+	})
+
+	public.HandleFunc("POST /csp-report", handlers.CSPReportHandler(reportStore, reportLimiter))
+	admin.HandleFunc("GET /csp-report", handlers.CSPReportSummaryHandler(reportStore))
+
+	admin.HandleFunc("GET /audit", handlers.AuditHandler(auditLog))
+	admin.HandleFunc("GET /trash/teachers", teachersHandler.Trash)
+	admin.HandleFunc("GET /trash/students", studentsHandler.Trash)
+	// Runtime and middleware counters such as http_panics. They include the
+	// command line and memory statistics, so they are only served when the
+	// admin routes are protected by keys or networks.
+	if apiKeys != nil || ipFilter != nil {
+		admin.Handle("GET /debug/vars", expvar.Handler())
+	}
+	if usage != nil {
+		authenticated.HandleFunc("GET /usage", handlers.UsageHandler(usage))
+	}
 
 	http.HandleFunc("/exces/", ExcesHandler)
 
+	router.PrintRoutes(os.Stdout)
+
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
 	}
-
-	//rl := mw.NewRateLimitter(5, time.Minute)
-	//hppOptions := mw.HTTPOptions{
-	//	CheckQuery:                  true,
-	//	CheckBody:                   true,
-	//	CheckBodyOnlyForContentType: "application/x-www-form-url-encoded",
-	//	Whitelist:                   []string{"sortBy", "sortOrder", "name", "age", "class"},
-	//}
-	// secureMux := mw.Cors(r1.Middleware(mw.ResponseTimeMiddleware(mw.SecurityHeaders(mw.Compression(mw.Hpp(hppOptions)(mux))))))
-	// secureMux := applyMiddlewareHandler(mux, mw.Hpp(hppOptions), mw.Compression, mw.SecurityHeaders, mw.ResponseTimeMiddleware, rl.Middleware, mw.Cors)
-	secureMux := mw.SecurityHeaders(mux)
+	// The write timeout must outlast the handler deadlines, or their timeout
+	// responses cannot be sent. The read timeout covers the routes without a
+	// deadline of their own; Timeout moves it for the others, like imports.
 	server := &http.Server{
-		Addr:      port,
-		Handler:   secureMux,
-		TLSConfig: tlsConfig,
+		Addr:              port,
+		Handler:           global.Then(mux),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: durationEnv("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       durationEnv("READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:      durationEnv("WRITE_TIMEOUT", time.Minute),
+		IdleTimeout:       durationEnv("IDLE_TIMEOUT", 2*time.Minute),
 	}
 
-	fmt.Print("Server listening on port ", port)
-	err := server.ListenAndServeTLS(cert, key)
+	fmt.Println("Server listening on port", port)
+	err = server.ListenAndServeTLS(cert, key)
 	if err != nil {
 		log.Fatal("Error starting server: ", err)
 	}
 }
-
-type Middleware func(http.Handler) http.Handler
-
-func applyMiddlewareHandler(handler http.Handler, middlewares ...Middleware) http.Handler {
-
-	for _, middleware := range middlewares {
-		handler = middleware(handler)
-	}
-	return handler
-
-}
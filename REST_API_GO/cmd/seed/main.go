@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"restapi/internal/models"
+	"restapi/internal/repository"
+	"restapi/internal/seed"
+)
+
+// seed loads a dataset into the store backend selected by STORE_BACKEND and
+// DATA_DIR. Running it again with the same dataset creates nothing new.
+func main() {
+	dataset := flag.String("dataset", "demo", "built-in dataset to load: demo or loadtest")
+	n := flag.Int("n", 100, "number of teachers and students generated by the loadtest dataset")
+	dir := flag.String("fixtures", "", "directory with teachers and students fixture files, instead of -dataset")
+	flag.Parse()
+
+	config := repository.ConfigFromEnv()
+	if config.Backend == repository.BackendMemory {
+		log.Print("Warning: the memory backend does not persist, nothing will be kept after seeding")
+	}
+
+	var ds seed.Dataset
+	var err error
+	if *dir != "" {
+		ds, err = seed.LoadDir(*dir)
+	} else {
+		ds, err = seed.Named(*dataset, *n)
+	}
+	if err != nil {
+		log.Fatal("Error loading dataset: ", err)
+	}
+
+	teachers, err := repository.Open[models.Teacher](config, "teachers")
+	if err != nil {
+		log.Fatal("Error opening teachers store: ", err)
+	}
+	students, err := repository.Open[models.Student](config, "students")
+	if err != nil {
+		log.Fatal("Error opening students store: ", err)
+	}
+
+	results, err := seed.Apply(ds, teachers, students)
+	for _, collection := range []string{"teachers", "students"} {
+		result := results[collection]
+		fmt.Printf("%s: %d created, %d already present\n", collection, result.Created, result.Skipped)
+	}
+	if err != nil {
+		log.Fatal("Error seeding: ", err)
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"restapi/internal/audit"
+)
+
+// AuditHandler serves GET /audit, filtered by the actor, resource, from and
+// to query parameters. from and to are RFC 3339 timestamps.
+func AuditHandler(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := audit.Filter{
+			Actor:    query.Get("actor"),
+			Resource: query.Get("resource"),
+		}
+
+		var err error
+		if from := query.Get("from"); from != "" {
+			if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+				http.Error(w, "Invalid from timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+		if to := query.Get("to"); to != "" {
+			if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+				http.Error(w, "Invalid to timestamp", http.StatusBadRequest)
+				return
+			}
+		}
+
+		writeList(w, auditLog.Query(filter))
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag renders a record version as a strong entity tag.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// precondition checks the If-Match header of r against the current version of
+// a record. It returns the version the store should enforce (0 when the client
+// sent no If-Match) and false when the header does not match.
+func precondition(r *http.Request, version int) (int, bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, true
+	}
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match uses strong comparison, so weak tags never match.
+		if tag == "*" || tag == current {
+			return version, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+
+	mw "restapi/internal/api/middlewares"
+	"restapi/internal/reports"
+)
+
+// maxReportSize bounds a report payload; browsers batch a few at most.
+const maxReportSize = 64 << 10
+
+// CSPReportHandler serves POST /csp-report, where browsers send CSP and
+// Network Error Logging violations as application/csp-report or
+// application/reports+json. Each client may send up to the limiter's budget;
+// reports beyond it are answered with 429 and dropped.
+func CSPReportHandler(store *reports.Store, limiter mw.Limiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := mw.ClientIP(r)
+		if !limiter.Allow(client).Allowed {
+			http.Error(w, "Too many reports", http.StatusTooManyRequests)
+			return
+		}
+
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxReportSize))
+		if err != nil {
+			mw.BodyError(w, err, "Invalid report")
+			return
+		}
+		parsed, err := reports.Parse(contentType, body, time.Now())
+		if errors.Is(err, reports.ErrUnsupported) {
+			http.Error(w, "Expected application/csp-report or application/reports+json", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid report", http.StatusBadRequest)
+			return
+		}
+
+		userAgent := r.UserAgent()
+		for _, report := range parsed {
+			if report.UserAgent == "" {
+				report.UserAgent = userAgent
+			}
+			if _, err := store.Add(client, report); err != nil {
+				slog.ErrorContext(r.Context(), "Error storing violation report", "err", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CSPReportSummaryHandler serves GET /csp-report: the number of violations
+// per directive and blocked URI.
+func CSPReportSummaryHandler(store *reports.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeList(w, store.Counts())
+	}
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	mw "restapi/internal/api/middlewares"
+	"restapi/internal/audit"
+	"restapi/internal/models"
+	"restapi/internal/repository"
+)
+
+// Resource serves the CRUD endpoints for one kind of record.
+type Resource[T any, P models.Record[T]] struct {
+	// Name is used in error messages, e.g. "Teacher not found".
+	Name string
+	// Collection names the resource in the audit trail, e.g. "teachers".
+	Collection string
+	Store      repository.Store[T]
+	Filter     func(v T, query url.Values) bool
+	// Columns are the JSON fields exported and imported as CSV columns.
+	Columns []string
+	// Audit, when set, records every successful mutation.
+	Audit *audit.Log
+}
+
+func (h *Resource[T, P]) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	list := make([]T, 0)
+	for _, v := range h.Store.List() {
+		if h.Filter == nil || h.Filter(v, query) {
+			list = append(list, v)
+		}
+	}
+	writeList(w, list)
+}
+
+// Trash lists the records that have been deleted but not yet purged.
+func (h *Resource[T, P]) Trash(w http.ResponseWriter, r *http.Request) {
+	writeList(w, h.Store.Trash())
+}
+
+// Restore takes a record back out of the trash.
+func (h *Resource[T, P]) Restore(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	trashed, v, err := h.Store.Restore(id)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	h.record(r, audit.ActionRestore, id, &trashed, &v)
+	h.writeRecord(w, http.StatusOK, v)
+}
+
+func (h *Resource[T, P]) Get(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	v, err := h.Store.Get(id)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	h.writeRecord(w, http.StatusOK, v)
+}
+
+func (h *Resource[T, P]) Create(w http.ResponseWriter, r *http.Request) {
+	var v T
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		mw.BodyError(w, err, "Invalid request body")
+		return
+	}
+	if err := P(&v).Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	created, err := h.Store.Create(v)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	h.record(r, audit.ActionCreate, P(&created).Metadata().ID, nil, &created)
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.Itoa(P(&created).Metadata().ID))
+	h.writeRecord(w, http.StatusCreated, created)
+}
+
+// Update replaces a record with the request body (PUT).
+func (h *Resource[T, P]) Update(w http.ResponseWriter, r *http.Request) {
+	var replacement T
+	if err := json.NewDecoder(r.Body).Decode(&replacement); err != nil {
+		mw.BodyError(w, err, "Invalid request body")
+		return
+	}
+	h.mutate(w, r, func(v *T) error {
+		*v = replacement
+		return nil
+	})
+}
+
+// Patch merges the fields present in the request body into a record.
+func (h *Resource[T, P]) Patch(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		mw.BodyError(w, err, "Invalid request body")
+		return
+	}
+	if !json.Valid(body) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	h.mutate(w, r, func(v *T) error {
+		return json.Unmarshal(body, v)
+	})
+}
+
+func (h *Resource[T, P]) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	current, err := h.Store.Get(id)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	version, ok := precondition(r, P(&current).Metadata().Version)
+	if !ok {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+	deleted, err := h.Store.Delete(id, version)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	// Delete only bumps the version and sets DeletedAt, so the record as it
+	// was just before is derived from the result rather than from the Get
+	// above, which another request may have outdated.
+	before := deleted
+	meta := P(&before).Metadata()
+	meta.Version--
+	meta.DeletedAt = nil
+	h.record(r, audit.ActionDelete, id, &before, &deleted)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Resource[T, P]) mutate(w http.ResponseWriter, r *http.Request, fn func(*T) error) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	current, err := h.Store.Get(id)
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	version, ok := precondition(r, P(&current).Metadata().Version)
+	if !ok {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Without If-Match another request may change the record between Get
+	// and Update, so the audit's before snapshot is taken under the store's
+	// lock.
+	var before T
+	var mutateErr, invalid error
+	updated, err := h.Store.Update(id, version, func(v *T) error {
+		before = *v
+		if mutateErr = fn(v); mutateErr != nil {
+			return mutateErr
+		}
+		invalid = P(v).Validate()
+		return invalid
+	})
+	if mutateErr != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if invalid != nil {
+		http.Error(w, invalid.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		h.storeError(w, err)
+		return
+	}
+	h.record(r, audit.ActionUpdate, id, &before, &updated)
+	h.writeRecord(w, http.StatusOK, updated)
+}
+
+// record appends a mutation to the audit trail. A failure to audit is logged
+// rather than reported, since the mutation itself has already happened.
+func (h *Resource[T, P]) record(r *http.Request, action string, id int, before, after *T) {
+	actor := mw.User(r)
+	if actor == "" {
+		actor = "anonymous"
+	}
+	h.audit(r.Context(), actor, mw.RequestID(r), action, id, before, after)
+}
+
+func (h *Resource[T, P]) audit(ctx context.Context, actor, requestID, action string, id int, before, after *T) {
+	if h.Audit == nil {
+		return
+	}
+	// Pass untyped nils so a missing snapshot is stored as JSON null.
+	var b, a any
+	if before != nil {
+		b = before
+	}
+	if after != nil {
+		a = after
+	}
+	entry, err := audit.NewEntry(action, h.Collection, id, b, a)
+	if err == nil {
+		entry.Actor = actor
+		entry.RequestID = requestID
+		err = h.Audit.Append(entry)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error recording audit entry", "collection", h.Collection, "id", id, "err", err)
+	}
+}
+
+// Purge permanently removes the records trashed before deletedBefore,
+// recording each in the audit trail as purged by the system. It makes the
+// handler a repository.Purger.
+func (h *Resource[T, P]) Purge(deletedBefore time.Time) int {
+	purged := h.Store.Purge(deletedBefore)
+	for _, v := range purged {
+		h.audit(context.Background(), "system", "", audit.ActionPurge, P(&v).Metadata().ID, &v, nil)
+	}
+	return len(purged)
+}
+
+func (h *Resource[T, P]) writeRecord(w http.ResponseWriter, status int, v T) {
+	w.Header().Set("ETag", etag(P(&v).Metadata().Version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		return
+	}
+}
+
+func (h *Resource[T, P]) storeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		http.Error(w, h.Name+" not found", http.StatusNotFound)
+	case errors.Is(err, repository.ErrVersionMismatch):
+		// Someone else changed the record between our read and the write.
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+	default:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func writeList[T any](w http.ResponseWriter, list []T) {
+	response := struct {
+		Status string `json:"status"`
+		Count  int    `json:"count"`
+		Data   []T    `json:"data"`
+	}{
+		Status: "success",
+		Count:  len(list),
+		Data:   list,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		return
+	}
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
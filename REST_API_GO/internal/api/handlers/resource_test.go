@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mw "restapi/internal/api/middlewares"
+	"restapi/internal/audit"
+	"restapi/internal/models"
+	"restapi/internal/repository"
+)
+
+// newTeachers returns a teachers handler over an in-memory store holding
+// one teacher, with ID 1, and routes to it as the server does.
+func newTeachers(t *testing.T) (*audit.Log, http.Handler) {
+	t.Helper()
+	store := repository.NewMemoryStore[models.Teacher, *models.Teacher]()
+	if _, err := store.Create(models.Teacher{FirstName: "Ada", LastName: "Lovelace", Class: "9A", Subject: "Math"}); err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := audit.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewTeachersHandler(store, auditLog)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /teachers/{id}", h.Get)
+	mux.Handle("PUT /teachers/{id}", mw.RequireIfMatch(http.HandlerFunc(h.Update)))
+	mux.Handle("PATCH /teachers/{id}", mw.RequireIfMatch(http.HandlerFunc(h.Patch)))
+	mux.Handle("DELETE /teachers/{id}", mw.RequireIfMatch(http.HandlerFunc(h.Delete)))
+	mux.HandleFunc("POST /teachers/{id}/restore", h.Restore)
+	mux.HandleFunc("GET /trash/teachers", h.Trash)
+	return auditLog, mux
+}
+
+// do sends a request with the given body and header name/value pairs.
+func do(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, target, reader)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestRestore(t *testing.T) {
+	auditLog, h := newTeachers(t)
+
+	if rec := do(h, http.MethodPost, "/teachers/1/restore", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("restoring a live teacher: got %d, want 404", rec.Code)
+	}
+	if rec := do(h, http.MethodDelete, "/teachers/1", "", "If-Match", `"1"`); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d", rec.Code)
+	}
+	if rec := do(h, http.MethodGet, "/teachers/1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("get of a trashed teacher: got %d, want 404", rec.Code)
+	}
+
+	rec := do(h, http.MethodPost, "/teachers/1/restore", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"3"` {
+		t.Fatalf("restore: got %d ETag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := do(h, http.MethodPost, "/teachers/1/restore", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("restoring twice: got %d, want 404", rec.Code)
+	}
+
+	entries := auditLog.Query(audit.Filter{})
+	if len(entries) != 2 || entries[1].Action != audit.ActionRestore {
+		t.Fatalf("audit entries = %+v", entries)
+	}
+	var before, after models.Teacher
+	if err := json.Unmarshal(entries[1].Before, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(entries[1].After, &after); err != nil {
+		t.Fatal(err)
+	}
+	// The snapshots are the trashed and the restored record.
+	if before.Version != 2 || before.DeletedAt == nil || after.Version != 3 || after.DeletedAt != nil {
+		t.Errorf("restore audited from %+v to %+v", before.Meta, after.Meta)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	_, h := newTeachers(t)
+	const patch = `{"subject":"Physics"}`
+
+	rec := do(h, http.MethodGet, "/teachers/1", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("get: got %d ETag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	for _, c := range []struct {
+		name    string
+		method  string
+		body    string
+		ifMatch string
+		status  int
+		etag    string
+	}{
+		{"PATCH without If-Match", http.MethodPatch, patch, "", http.StatusPreconditionRequired, ""},
+		{"PUT without If-Match", http.MethodPut, `{"firstName":"Ada","lastName":"Lovelace","class":"9A","subject":"Physics"}`, "", http.StatusPreconditionRequired, ""},
+		{"DELETE without If-Match", http.MethodDelete, "", "", http.StatusPreconditionRequired, ""},
+		{"stale If-Match", http.MethodPatch, patch, `"0"`, http.StatusPreconditionFailed, ""},
+		{"weak If-Match", http.MethodPatch, patch, `W/"1"`, http.StatusPreconditionFailed, ""},
+		{"matching If-Match", http.MethodPatch, patch, `"1"`, http.StatusOK, `"2"`},
+		{"the old version again", http.MethodPatch, patch, `"1"`, http.StatusPreconditionFailed, ""},
+		{"one of a list", http.MethodPatch, `{"class":"9B"}`, `"7", "2"`, http.StatusOK, `"3"`},
+		{"wildcard", http.MethodPut, `{"firstName":"Ada","lastName":"Lovelace","class":"9C","subject":"Physics"}`, "*", http.StatusOK, `"4"`},
+		{"invalid update", http.MethodPatch, `{"class":""}`, `"4"`, http.StatusBadRequest, ""},
+		{"stale DELETE", http.MethodDelete, "", `"3"`, http.StatusPreconditionFailed, ""},
+		{"DELETE", http.MethodDelete, "", `"4"`, http.StatusNoContent, ""},
+		{"update of a trashed teacher", http.MethodPatch, patch, "*", http.StatusNotFound, ""},
+	} {
+		var header []string
+		if c.ifMatch != "" {
+			header = []string{"If-Match", c.ifMatch}
+		}
+		rec := do(h, c.method, "/teachers/1", c.body, header...)
+		if rec.Code != c.status || rec.Header().Get("ETag") != c.etag {
+			t.Errorf("%s: got %d ETag %q, want %d ETag %q", c.name, rec.Code, rec.Header().Get("ETag"), c.status, c.etag)
+		}
+	}
+}
+
+func TestUpdateKeepsMetadata(t *testing.T) {
+	_, h := newTeachers(t)
+	// The body cannot move, version or trash the record.
+	rec := do(h, http.MethodPatch, "/teachers/1", `{"id":9,"version":40,"deletedAt":"2026-01-01T00:00:00Z"}`, "If-Match", `"1"`)
+	var got models.Teacher
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || got.ID != 1 || got.Version != 2 || got.DeletedAt != nil {
+		t.Errorf("got %d %+v", rec.Code, got.Meta)
+	}
+}
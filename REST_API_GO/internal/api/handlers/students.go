@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/url"
+
+	"restapi/internal/audit"
+	"restapi/internal/models"
+	"restapi/internal/repository"
+)
+
+func NewStudentsHandler(store repository.Store[models.Student], auditLog *audit.Log) *Resource[models.Student, *models.Student] {
+	return &Resource[models.Student, *models.Student]{
+		Name:       "Student",
+		Collection: "students",
+		Store:      store,
+		Filter:     filterStudent,
+		Audit:      auditLog,
+		Columns:    []string{"id", "firstName", "lastName", "email", "class"},
+	}
+}
+
+func filterStudent(s models.Student, query url.Values) bool {
+	if firstName := query.Get("firstName"); firstName != "" && s.FirstName != firstName {
+		return false
+	}
+	if lastName := query.Get("lastName"); lastName != "" && s.LastName != lastName {
+		return false
+	}
+	if class := query.Get("class"); class != "" && s.Class != class {
+		return false
+	}
+	return true
+}
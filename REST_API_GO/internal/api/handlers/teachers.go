@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/url"
+
+	"restapi/internal/audit"
+	"restapi/internal/models"
+	"restapi/internal/repository"
+)
+
+func NewTeachersHandler(store repository.Store[models.Teacher], auditLog *audit.Log) *Resource[models.Teacher, *models.Teacher] {
+	return &Resource[models.Teacher, *models.Teacher]{
+		Name:       "Teacher",
+		Collection: "teachers",
+		Store:      store,
+		Filter:     filterTeacher,
+		Audit:      auditLog,
+		Columns:    []string{"id", "firstName", "lastName", "class", "subject"},
+	}
+}
+
+func filterTeacher(t models.Teacher, query url.Values) bool {
+	if firstName := query.Get("firstName"); firstName != "" && t.FirstName != firstName {
+		return false
+	}
+	if lastName := query.Get("lastName"); lastName != "" && t.LastName != lastName {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	mw "restapi/internal/api/middlewares"
+	"restapi/internal/audit"
+	"restapi/internal/models"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// flushEvery is how many rows are buffered before exported rows are pushed
+// to the client, or imported rows are stored.
+const flushEvery = 100
+
+// maxImportErrors is how many errors an import report lists; the report is
+// marked truncated past it.
+const maxImportErrors = 100
+
+// Export streams the (filtered) records as CSV or NDJSON. The format comes
+// from ?format= or, failing that, the Accept header, and defaults to CSV.
+func (h *Resource[T, P]) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		accept := r.Header.Get("Accept")
+		format = formatCSV
+		if strings.Contains(accept, "application/x-ndjson") || strings.Contains(accept, "application/ndjson") {
+			format = formatNDJSON
+		}
+	}
+
+	query := r.URL.Query()
+	rc := http.NewResponseController(w)
+	rows := 0
+
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+h.Collection+`.csv"`)
+		cw := csv.NewWriter(w)
+		if err := cw.Write(h.Columns); err != nil {
+			return
+		}
+		for _, v := range h.Store.List() {
+			if h.Filter != nil && !h.Filter(v, query) {
+				continue
+			}
+			row, err := h.csvRow(v)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error exporting", "collection", h.Collection, "err", err)
+				return
+			}
+			if err := cw.Write(row); err != nil {
+				return
+			}
+			if rows++; rows%flushEvery == 0 {
+				cw.Flush()
+				_ = rc.Flush()
+			}
+		}
+		cw.Flush()
+	case formatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+h.Collection+`.ndjson"`)
+		enc := json.NewEncoder(w)
+		for _, v := range h.Store.List() {
+			if h.Filter != nil && !h.Filter(v, query) {
+				continue
+			}
+			if err := enc.Encode(v); err != nil {
+				return
+			}
+			if rows++; rows%flushEvery == 0 {
+				_ = rc.Flush()
+			}
+		}
+	default:
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+	}
+}
+
+// csvRow renders the exported columns of v.
+func (h *Resource[T, P]) csvRow(v T) ([]string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	row := make([]string, len(h.Columns))
+	for i, column := range h.Columns {
+		switch value := fields[column].(type) {
+		case nil:
+		case string:
+			row[i] = escapeFormula(value)
+		default:
+			row[i] = fmt.Sprint(value)
+		}
+	}
+	return row, nil
+}
+
+// escapeFormula keeps spreadsheets from running a text cell as a formula by
+// prefixing the ones that would start one with a quote.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type importError struct {
+	// Line is the line of the file the row starts on; the CSV header is line 1.
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type importReport struct {
+	Status string `json:"status"`
+	DryRun bool   `json:"dryRun"`
+	Rows   int    `json:"rows"`
+	// Imported counts the rows stored, or in a dry run the rows that would be.
+	Imported       int           `json:"imported"`
+	Rejected       int           `json:"rejected"`
+	IgnoredColumns []string      `json:"ignoredColumns"`
+	Errors         []importError `json:"errors"`
+	// Truncated is set when there were more errors than the report lists.
+	Truncated bool `json:"truncated"`
+}
+
+// addError lists e unless the report already lists maxImportErrors.
+func (report *importReport) addError(e importError) {
+	if len(report.Errors) == maxImportErrors {
+		report.Truncated = true
+		return
+	}
+	report.Errors = append(report.Errors, e)
+}
+
+// Import reads CSV or NDJSON from the request body one row at a time and
+// creates a record for every valid row. Invalid rows are skipped and listed
+// in the report. Valid rows are stored in batches of flushEvery as the file
+// is read. With ?dryRun=true nothing is stored.
+//
+// Source columns can be renamed with ?map=Source:field, e.g.
+// ?map=First%20Name:firstName, which may be repeated.
+func (h *Resource[T, P]) Import(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(query.Get("dryRun"))
+
+	mapping, err := h.columnMapping(query["map"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = formatCSV
+		case "application/x-ndjson", "application/ndjson":
+			format = formatNDJSON
+		}
+	}
+
+	var rows rowReader
+	switch format {
+	case formatCSV:
+		rows, err = newCSVRows(r.Body)
+	case formatNDJSON:
+		rows = newNDJSONRows(r.Body)
+	default:
+		http.Error(w, "Import expects text/csv or application/x-ndjson", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		mw.BodyError(w, err, "Invalid import file: "+err.Error())
+		return
+	}
+
+	report := importReport{
+		DryRun:         dryRun,
+		IgnoredColumns: make([]string, 0),
+		Errors:         make([]importError, 0),
+	}
+	// The valid rows are created in batches, so a file store is written
+	// once per batch rather than once per row, and a large import is not
+	// held in memory at once.
+	pending := make([]T, 0, flushEvery)
+	pendingLines := make([]int, 0, flushEvery)
+	store := func() {
+		if len(pending) == 0 {
+			return
+		}
+		created, err := h.Store.CreateMany(pending)
+		if err != nil {
+			report.Rejected += len(pending)
+			for _, line := range pendingLines {
+				report.addError(importError{Line: line, Message: err.Error()})
+			}
+		} else {
+			for i := range created {
+				h.record(r, audit.ActionCreate, P(&created[i]).Metadata().ID, nil, &created[i])
+			}
+			report.Imported += len(created)
+		}
+		pending, pendingLines = pending[:0], pendingLines[:0]
+	}
+	for {
+		line, fields, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			report.Rows++
+			report.Rejected++
+			report.addError(importError{Line: line, Message: rowErr.Error()})
+			continue
+		}
+		if err != nil {
+			// The batches stored so far stay; the report is not sent.
+			mw.BodyError(w, err, "Error reading import file: "+err.Error())
+			return
+		}
+		report.Rows++
+
+		record := make(map[string]any, len(fields))
+		for column, value := range fields {
+			field, ok := mapping[column]
+			if !ok {
+				field = column
+			}
+			if field == "id" || !slices.Contains(h.Columns, field) {
+				if !slices.Contains(report.IgnoredColumns, column) {
+					report.IgnoredColumns = append(report.IgnoredColumns, column)
+				}
+				continue
+			}
+			record[field] = value
+		}
+
+		v, errs := decodeRow[T, P](record)
+		if len(errs) > 0 {
+			report.Rejected++
+			for _, fe := range errs {
+				report.addError(importError{Line: line, Field: fe.Field, Message: fe.Message})
+			}
+			continue
+		}
+		if dryRun {
+			report.Imported++
+			continue
+		}
+		pending = append(pending, v)
+		pendingLines = append(pendingLines, line)
+		if len(pending) == flushEvery {
+			store()
+		}
+	}
+	store()
+
+	report.Status = "success"
+	if report.Rejected > 0 {
+		report.Status = "error"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		return
+	}
+}
+
+// columnMapping parses ?map=Source:field pairs.
+func (h *Resource[T, P]) columnMapping(pairs []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		source, field, ok := strings.Cut(pair, ":")
+		if !ok || source == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, expected Source:field", pair)
+		}
+		if field == "id" || !slices.Contains(h.Columns, field) {
+			return nil, fmt.Errorf("column mapping %q targets unknown field %q", pair, field)
+		}
+		mapping[source] = field
+	}
+	return mapping, nil
+}
+
+// decodeRow turns an imported row into a validated record.
+func decodeRow[T any, P models.Record[T]](record map[string]any) (T, []*models.FieldError) {
+	var v T
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = json.Unmarshal(data, &v)
+	}
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return v, []*models.FieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}}
+		}
+		return v, []*models.FieldError{{Message: err.Error()}}
+	}
+	return v, models.FieldErrors(P(&v).Validate())
+}
+
+// rowError is a problem confined to one row; the import carries on after it.
+type rowError struct {
+	err error
+}
+
+func (e *rowError) Error() string {
+	return e.err.Error()
+}
+
+// rowReader yields the rows of an import file one at a time, together with
+// the line each row starts on. It returns io.EOF after the last row.
+type rowReader interface {
+	next() (int, map[string]any, error)
+}
+
+type csvRows struct {
+	reader *csv.Reader
+	header []string
+}
+
+func newCSVRows(body io.Reader) (*csvRows, error) {
+	reader := csv.NewReader(body)
+	reader.ReuseRecord = true
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing CSV header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Rows are checked against the header ourselves so a short row is a
+	// row error rather than the end of the import.
+	reader.FieldsPerRecord = -1
+	return &csvRows{reader: reader, header: slices.Clone(header)}, nil
+}
+
+func (c *csvRows) next() (int, map[string]any, error) {
+	row, err := c.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return parseErr.StartLine, nil, &rowError{err: parseErr.Err}
+		}
+		return 0, nil, err
+	}
+	line, _ := c.reader.FieldPos(0)
+	if len(row) != len(c.header) {
+		return line, nil, &rowError{err: fmt.Errorf("expected %d columns, found %d", len(c.header), len(row))}
+	}
+	fields := make(map[string]any, len(row))
+	for i, value := range row {
+		fields[c.header[i]] = value
+	}
+	return line, fields, nil
+}
+
+type ndjsonRows struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONRows(body io.Reader) *ndjsonRows {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &ndjsonRows{scanner: scanner}
+}
+
+func (n *ndjsonRows) next() (int, map[string]any, error) {
+	for n.scanner.Scan() {
+		n.line++
+		line := bytes.TrimSpace(n.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var fields map[string]any
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return n.line, nil, &rowError{err: errors.New("invalid JSON object")}
+		}
+		return n.line, fields, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	return 0, nil, io.EOF
+}
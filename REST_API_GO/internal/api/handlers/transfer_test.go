@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"restapi/internal/models"
+	"restapi/internal/repository"
+)
+
+// batchingStore records the size of every CreateMany batch.
+type batchingStore struct {
+	repository.Store[models.Teacher]
+	batches []int
+}
+
+func (s *batchingStore) CreateMany(vs []models.Teacher) ([]models.Teacher, error) {
+	s.batches = append(s.batches, len(vs))
+	return s.Store.CreateMany(vs)
+}
+
+func importCSV(h http.HandlerFunc, body string) (*httptest.ResponseRecorder, importReport) {
+	r := httptest.NewRequest(http.MethodPost, "/teachers/import", strings.NewReader(body))
+	r.Header.Set("Content-Type", "text/csv")
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	var report importReport
+	_ = json.Unmarshal(rec.Body.Bytes(), &report)
+	return rec, report
+}
+
+func TestImportBatches(t *testing.T) {
+	store := &batchingStore{Store: repository.NewMemoryStore[models.Teacher, *models.Teacher]()}
+	h := NewTeachersHandler(store, nil)
+
+	var body strings.Builder
+	body.WriteString("firstName,lastName,class,subject\n")
+	for i := range 2*flushEvery + 10 {
+		fmt.Fprintf(&body, "Ada%d,Lovelace,9A,Math\n", i)
+	}
+	rec, report := importCSV(h.Import, body.String())
+	if rec.Code != http.StatusOK || report.Imported != 2*flushEvery+10 || report.Rejected != 0 {
+		t.Fatalf("got %d %+v", rec.Code, report)
+	}
+	if want := []int{flushEvery, flushEvery, 10}; fmt.Sprint(store.batches) != fmt.Sprint(want) {
+		t.Errorf("CreateMany batches = %v, want %v", store.batches, want)
+	}
+	if n := len(store.List()); n != 2*flushEvery+10 {
+		t.Errorf("%d teachers stored", n)
+	}
+}
+
+func TestImportErrorsTruncated(t *testing.T) {
+	h := NewTeachersHandler(repository.NewMemoryStore[models.Teacher, *models.Teacher](), nil)
+
+	var body strings.Builder
+	body.WriteString("firstName,lastName,class,subject\n")
+	for range maxImportErrors + 5 {
+		body.WriteString("Ada,,9A,Math\n")
+	}
+	body.WriteString("Ada,Lovelace,9A,Math\n")
+	_, report := importCSV(h.Import, body.String())
+	if report.Rejected != maxImportErrors+5 || report.Imported != 1 {
+		t.Errorf("rejected %d and imported %d", report.Rejected, report.Imported)
+	}
+	if len(report.Errors) != maxImportErrors || !report.Truncated {
+		t.Errorf("%d errors listed, truncated %v", len(report.Errors), report.Truncated)
+	}
+
+	_, report = importCSV(h.Import, "firstName,lastName,class,subject\nAda,,9A,Math\n")
+	if len(report.Errors) != 1 || report.Truncated {
+		t.Errorf("one error: %d listed, truncated %v", len(report.Errors), report.Truncated)
+	}
+}
+
+func TestExportEscapesFormulas(t *testing.T) {
+	store := repository.NewMemoryStore[models.Teacher, *models.Teacher]()
+	if _, err := store.Create(models.Teacher{FirstName: "=HYPERLINK(\"http://evil.example\")", LastName: "+1", Class: "-9A", Subject: "@SUM(A1)"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(models.Teacher{FirstName: "Ada", LastName: "Lovelace", Class: "9A", Subject: "Math"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewTeachersHandler(store, nil)
+	rec := httptest.NewRecorder()
+	h.Export(rec, httptest.NewRequest(http.MethodGet, "/teachers/export?format=csv", nil))
+
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"id", "firstName", "lastName", "class", "subject"},
+		{"1", "'=HYPERLINK(\"http://evil.example\")", "'+1", "'-9A", "'@SUM(A1)"},
+		{"2", "Ada", "Lovelace", "9A", "Math"},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("exported\n%q\nwant\n%q", rows, want)
+	}
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	mw "restapi/internal/api/middlewares"
+	"restapi/internal/quota"
+)
+
+// UsageHandler serves GET /usage: the requests counted per period and route
+// group, for billing. ?period= selects day or month, the default being both.
+// Admins may pass ?key= to see one key or leave it out to see every key;
+// everyone else only sees the key they authenticated with.
+func UsageHandler(store quota.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey := mw.CurrentAPIKey(r)
+		if apiKey == nil {
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+		period := query.Get("period")
+		if period != "" && period != quota.Day && period != quota.Month {
+			http.Error(w, "Invalid period", http.StatusBadRequest)
+			return
+		}
+		key := apiKey.Name
+		if apiKey.Role == mw.RoleAdmin {
+			key = query.Get("key")
+		}
+
+		usage, err := store.Usage(r.Context(), key, period)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeList(w, usage)
+	}
+}
@@ -0,0 +1,186 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats.
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+	// LogFormatCommon is the NCSA Common Log Format.
+	LogFormatCommon = "common"
+	// LogFormatCombined is the Common Log Format plus referer and user agent.
+	LogFormatCombined = "combined"
+)
+
+// AccessLogOptions configures AccessLog.
+type AccessLogOptions struct {
+	// Format is one of the LogFormat constants; LogFormatText by default.
+	Format string
+	// Output receives the log; os.Stdout by default.
+	Output io.Writer
+	// Exclude lists path prefixes that are never logged, e.g. "/health".
+	Exclude []string
+	// Sample maps path prefixes to the fraction of their requests that is
+	// logged, e.g. {"/teachers": 0.1}. The longest matching prefix applies.
+	// Server errors are always logged.
+	Sample map[string]float64
+	// ResponseTimeHeader names the header carrying the time the handler
+	// took until the headers were sent, e.g. "X-Response-Time". Empty leaves
+	// it out.
+	ResponseTimeHeader string
+}
+
+// AccessLog logs one line per request with its status, size, latency,
+// client, user agent, request ID and authenticated user. It must run after
+// RealIP and RequestIDs so the client address and request ID are known.
+func AccessLog(options AccessLogOptions) (func(http.Handler) http.Handler, error) {
+	fmt.Println("Access Log Middleware...")
+	if options.Output == nil {
+		options.Output = os.Stdout
+	}
+	var write func(e *accessLogEntry)
+	switch options.Format {
+	case "", LogFormatText:
+		logger := slog.New(slog.NewTextHandler(options.Output, nil))
+		write = func(e *accessLogEntry) { e.slog(logger) }
+	case LogFormatJSON:
+		logger := slog.New(slog.NewJSONHandler(options.Output, nil))
+		write = func(e *accessLogEntry) { e.slog(logger) }
+	case LogFormatCommon, LogFormatCombined:
+		var mu sync.Mutex
+		combined := options.Format == LogFormatCombined
+		write = func(e *accessLogEntry) {
+			line := e.clf(combined)
+			mu.Lock()
+			defer mu.Unlock()
+			_, _ = io.WriteString(options.Output, line)
+		}
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", options.Format)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.Path
+			for _, prefix := range options.Exclude {
+				if strings.HasPrefix(path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			entry := &accessLogEntry{request: r, user: User(r)}
+			rw := newResponseWritter(w, options.ResponseTimeHeader)
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), logEntryKey, entry)))
+
+			if rw.status < 500 && !sampled(options.Sample, path) {
+				return
+			}
+			entry.status = rw.status
+			entry.bytes = rw.bytes
+			entry.start = rw.start
+			entry.latency = time.Since(rw.start)
+			entry.requestID = RequestID(r)
+			write(entry)
+		})
+	}, nil
+}
+
+// sampled decides whether a request to path is logged.
+func sampled(sample map[string]float64, path string) bool {
+	rate, longest := 1.0, -1
+	for prefix, r := range sample {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			rate, longest = r, len(prefix)
+		}
+	}
+	return rate >= 1 || rand.Float64() < rate
+}
+
+type accessLogEntry struct {
+	request   *http.Request
+	user      string
+	requestID string
+	status    int
+	bytes     int64
+	start     time.Time
+	latency   time.Duration
+}
+
+func (e *accessLogEntry) slog(logger *slog.Logger) {
+	r := e.request
+	level := slog.LevelInfo
+	if e.status >= 500 {
+		level = slog.LevelError
+	}
+	logger.LogAttrs(r.Context(), level, "request",
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("query", r.URL.RawQuery),
+		slog.String("proto", r.Proto),
+		slog.Int("status", e.status),
+		slog.Int64("bytes", e.bytes),
+		slog.Duration("latency", e.latency),
+		slog.String("remoteIp", ClientIP(r)),
+		slog.String("userAgent", r.UserAgent()),
+		slog.String("requestId", e.requestID),
+		slog.String("user", e.user),
+	)
+}
+
+// clf renders the entry in the Common, or Combined, Log Format.
+func (e *accessLogEntry) clf(combined bool) string {
+	r := e.request
+	var b strings.Builder
+	b.WriteString(ClientIP(r))
+	b.WriteString(" - ")
+	b.WriteString(clfField(e.user))
+	b.WriteString(" [")
+	b.WriteString(e.start.Format("02/Jan/2006:15:04:05 -0700"))
+	b.WriteString(`] "`)
+	b.WriteString(clfEscape(r.Method + " " + r.URL.RequestURI() + " " + r.Proto))
+	b.WriteString(`" `)
+	b.WriteString(strconv.Itoa(e.status))
+	b.WriteByte(' ')
+	if e.bytes == 0 {
+		b.WriteByte('-')
+	} else {
+		b.WriteString(strconv.FormatInt(e.bytes, 10))
+	}
+	if combined {
+		b.WriteString(` "`)
+		b.WriteString(clfEscape(clfField(r.Referer())))
+		b.WriteString(`" "`)
+		b.WriteString(clfEscape(clfField(r.UserAgent())))
+		b.WriteByte('"')
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// clfField renders an empty field as "-".
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfEscape keeps client supplied values from breaking out of their quotes
+// or forging log lines.
+func clfEscape(s string) string {
+	quoted := strconv.Quote(s)
+	return quoted[1 : len(quoted)-1]
+}
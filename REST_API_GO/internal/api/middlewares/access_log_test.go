@@ -0,0 +1,142 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// logged returns an access log writing to a buffer in front of a handler
+// that authenticates the user "ada" and answers with the status in the
+// "status" query parameter.
+func logged(t *testing.T, options AccessLogOptions) (*bytes.Buffer, http.Handler) {
+	t.Helper()
+	var buf bytes.Buffer
+	options.Output = &buf
+	accessLog, err := AccessLog(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &buf, RequestIDs(accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(WithUser(r.Context(), "ada"))
+		if r.URL.Query().Get("status") == "500" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, "hello")
+	})))
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	buf, h := logged(t, AccessLogOptions{Format: LogFormatJSON})
+	get(h, "/teachers?sort=name", "X-Request-ID", "abc", "User-Agent", "test/1.0")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%v: %q", err, buf)
+	}
+	for key, want := range map[string]any{
+		"level": "INFO", "msg": "request", "method": "GET", "path": "/teachers", "query": "sort=name",
+		"proto": "HTTP/1.1", "status": 200.0, "bytes": 5.0, "remoteIp": "192.0.2.1",
+		"userAgent": "test/1.0", "requestId": "abc", "user": "ada",
+	} {
+		if line[key] != want {
+			t.Errorf("%s = %v, want %v", key, line[key], want)
+		}
+	}
+	if _, ok := line["latency"].(float64); !ok {
+		t.Errorf("latency = %v", line["latency"])
+	}
+
+	buf.Reset()
+	get(h, "/teachers?status=500")
+	if !strings.Contains(buf.String(), `"level":"ERROR"`) || !strings.Contains(buf.String(), `"status":500`) {
+		t.Errorf("server error: %q", buf)
+	}
+}
+
+func TestAccessLogText(t *testing.T) {
+	buf, h := logged(t, AccessLogOptions{})
+	get(h, "/teachers", "X-Request-ID", "abc")
+	if line := buf.String(); !strings.Contains(line, "level=INFO msg=request method=GET path=/teachers") ||
+		!strings.Contains(line, "status=200 bytes=5") || !strings.Contains(line, "requestId=abc user=ada") {
+		t.Errorf("log = %q", line)
+	}
+}
+
+func TestAccessLogCommon(t *testing.T) {
+	clf := `^192\.0\.2\.1 - ada \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /teachers\?sort=name HTTP/1\.1" 200 5`
+
+	buf, h := logged(t, AccessLogOptions{Format: LogFormatCommon})
+	get(h, "/teachers?sort=name", "Referer", "https://app.example.com/")
+	if !regexp.MustCompile(clf + "\n$").MatchString(buf.String()) {
+		t.Errorf("common: %q", buf)
+	}
+
+	buf, h = logged(t, AccessLogOptions{Format: LogFormatCombined})
+	get(h, "/teachers?sort=name", "Referer", "https://app.example.com/")
+	if !regexp.MustCompile(clf + ` "https://app\.example\.com/" "-"` + "\n$").MatchString(buf.String()) {
+		t.Errorf("combined: %q", buf)
+	}
+
+	// Quotes and newlines from the client cannot forge a line.
+	buf.Reset()
+	get(h, "/", "User-Agent", "x\" 200 1\n10.0.0.1 - admin")
+	if strings.Count(buf.String(), "\n") != 1 || !strings.Contains(buf.String(), `"x\" 200 1\n10.0.0.1 - admin"`) {
+		t.Errorf("escaped user agent: %q", buf)
+	}
+}
+
+func TestAccessLogUnknownFormat(t *testing.T) {
+	if _, err := AccessLog(AccessLogOptions{Format: "xml"}); err == nil {
+		t.Error("unknown format: no error")
+	}
+}
+
+func TestAccessLogExcludeAndSample(t *testing.T) {
+	buf, h := logged(t, AccessLogOptions{
+		Format:  LogFormatCommon,
+		Exclude: []string{"/health"},
+		Sample:  map[string]float64{"/teachers": 0, "/teachers/reports": 1},
+	})
+
+	for _, c := range []struct {
+		target string
+		logged bool
+	}{
+		{"/health", false},
+		{"/healthz?status=500", false},
+		{"/students", true},
+		{"/teachers", false},
+		{"/teachers/1", false},
+		// Server errors are logged whatever the sample rate.
+		{"/teachers?status=500", true},
+		// The longest prefix wins.
+		{"/teachers/reports/1", true},
+	} {
+		buf.Reset()
+		rec := get(h, c.target)
+		if rec.Body.Len() == 0 {
+			t.Errorf("%s: no response", c.target)
+		}
+		if got := buf.Len() > 0; got != c.logged {
+			t.Errorf("%s: logged %v, want %v", c.target, got, c.logged)
+		}
+	}
+}
+
+func TestAccessLogResponseTime(t *testing.T) {
+	_, h := logged(t, AccessLogOptions{ResponseTimeHeader: "X-Response-Time"})
+	if rec := get(h, "/"); rec.Header().Get("X-Response-Time") == "" {
+		t.Error("no X-Response-Time header")
+	}
+	// Excluded requests bypass the wrapper entirely.
+	_, h = logged(t, AccessLogOptions{ResponseTimeHeader: "X-Response-Time", Exclude: []string{"/"}})
+	if rec := get(h, "/"); rec.Header().Get("X-Response-Time") != "" {
+		t.Error("X-Response-Time on an excluded request")
+	}
+}
@@ -0,0 +1,219 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"restapi/internal/quota"
+)
+
+// Roles an APIKey can have.
+const (
+	RolePartner = "partner"
+	RoleStaff   = "staff"
+	RoleAdmin   = "admin"
+)
+
+// APIKey is a credential issued to a partner integration or a member of
+// staff. Only the SHA-256 of the secret is kept, so the keys file can be
+// checked in to configuration management.
+type APIKey struct {
+	// Name identifies the key's owner; it becomes the request's user.
+	Name string `json:"name"`
+	// KeySHA256 is the hex encoded SHA-256 of the secret,
+	// e.g. the output of `printf %s "$KEY" | sha256sum`.
+	KeySHA256 string `json:"keySha256"`
+	// Role is one of the Role constants.
+	Role string `json:"role"`
+	// Quota limits the key's daily and monthly requests.
+	Quota quota.Limits `json:"quota"`
+}
+
+// APIKeys looks up keys by the hash of their secret.
+type APIKeys struct {
+	byHash map[[sha256.Size]byte]*APIKey
+}
+
+// LoadAPIKeys reads a JSON array of APIKey from path.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []*APIKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := &APIKeys{byHash: make(map[[sha256.Size]byte]*APIKey, len(list))}
+	for _, key := range list {
+		var hash [sha256.Size]byte
+		if n, err := hex.Decode(hash[:], []byte(key.KeySHA256)); err != nil || n != sha256.Size {
+			return nil, fmt.Errorf("%s: API key %q has an invalid keySha256", path, key.Name)
+		}
+		if key.Name == "" {
+			return nil, fmt.Errorf("%s: API key without a name", path)
+		}
+		switch key.Role {
+		case RolePartner, RoleStaff, RoleAdmin:
+		default:
+			return nil, fmt.Errorf("%s: API key %q has unknown role %q", path, key.Name, key.Role)
+		}
+		keys.byHash[hash] = key
+	}
+	return keys, nil
+}
+
+// lookup finds the key for secret. Only hashes are compared, so the time the
+// lookup takes reveals nothing about the secrets.
+func (k *APIKeys) lookup(secret string) *APIKey {
+	return k.byHash[sha256.Sum256([]byte(secret))]
+}
+
+// AuthenticateOptions configures AuthenticateWithOptions.
+type AuthenticateOptions struct {
+	// MaxFailures is how many unknown keys a client IP may send within
+	// FailureWindow; 10 by default. After that its requests with a key are
+	// rejected with 429 until the window is over, without being checked, so
+	// keys cannot be guessed at the speed of the server.
+	MaxFailures int
+	// FailureWindow is 1 minute by default.
+	FailureWindow time.Duration
+}
+
+// Authenticate applies the default AuthenticateOptions.
+func Authenticate(keys *APIKeys) func(http.Handler) http.Handler {
+	return AuthenticateWithOptions(keys, AuthenticateOptions{})
+}
+
+// AuthenticateWithOptions identifies requests carrying an X-API-Key header,
+// making the key's name the request's User. Requests without the header stay
+// anonymous; an unknown key is rejected with 401.
+//
+// Failed attempts are throttled here rather than by the rate limiter, which
+// runs after authentication so that limits can be keyed by user and so never
+// sees the rejected requests.
+func AuthenticateWithOptions(keys *APIKeys, options AuthenticateOptions) func(http.Handler) http.Handler {
+	fmt.Println("Authenticate Middleware...")
+	if options.MaxFailures <= 0 {
+		options.MaxFailures = 10
+	}
+	if options.FailureWindow <= 0 {
+		options.FailureWindow = time.Minute
+	}
+	failures, err := NewLimiter(RateLimitOptions{
+		Algorithm: AlgorithmFixedWindow,
+		Limit:     options.MaxFailures,
+		Window:    options.FailureWindow,
+	})
+	if err != nil {
+		panic(err)
+	}
+	locked := &lockouts{until: make(map[string]time.Time)}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret := r.Header.Get("X-API-Key")
+			if secret == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ip := ClientIP(r)
+			if retry := locked.remaining(ip); retry > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(retry)))
+				http.Error(w, "Too many failed authentication attempts", http.StatusTooManyRequests)
+				return
+			}
+			key := keys.lookup(secret)
+			if key == nil {
+				if d := failures.Allow(ip); !d.Allowed {
+					locked.lock(ip, d.RetryAfter)
+				}
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			ctx := context.WithValue(WithUser(r.Context(), key.Name), apiKeyKey, key)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// lockouts holds the client IPs that sent too many unknown keys.
+type lockouts struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// remaining returns how much longer ip is locked out.
+func (l *lockouts) remaining(ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, ok := l.until[ip]
+	if !ok {
+		return 0
+	}
+	retry := time.Until(until)
+	if retry <= 0 {
+		delete(l.until, ip)
+	}
+	return retry
+}
+
+// lock locks ip out for d, dropping the lockouts that are over.
+func (l *lockouts) lock(ip string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for other, until := range l.until {
+		if !now.Before(until) {
+			delete(l.until, other)
+		}
+	}
+	l.until[ip] = now.Add(d)
+}
+
+// CurrentAPIKey returns the key the request was authenticated with, if any.
+func CurrentAPIKey(r *http.Request) *APIKey {
+	key, _ := r.Context().Value(apiKeyKey).(*APIKey)
+	return key
+}
+
+// RequireAPIKey rejects anonymous requests with 401. It runs after
+// Authenticate.
+func RequireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if CurrentAPIKey(r) == nil {
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole rejects requests whose API key lacks one of roles with 403,
+// and anonymous requests with 401. It runs after Authenticate.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := CurrentAPIKey(r)
+			if key == nil {
+				http.Error(w, "API key required", http.StatusUnauthorized)
+				return
+			}
+			if !slices.Contains(roles, key.Role) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// BodyLimitOptions configures BodyLimit.
+type BodyLimitOptions struct {
+	// MaxBytes caps request bodies as sent, before any decompression;
+	// 1 MiB by default.
+	MaxBytes int64
+	// Routes override MaxBytes per ServeMux pattern such as
+	// "POST /teachers/import".
+	Routes map[string]int64
+}
+
+// BodyError reports a request body that could not be read, by a middleware
+// or a handler: 413 when it exceeded a size limit, otherwise 400 with
+// message.
+func BodyError(w http.ResponseWriter, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, message, http.StatusBadRequest)
+}
+
+// BodyLimit rejects request bodies larger than the route allows with 413.
+// Bodies that announce their size are rejected up front; the others fail the
+// handler's read with an *http.MaxBytesError once they run over, which the
+// handlers answer with 413 as well.
+func BodyLimit(options BodyLimitOptions) func(http.Handler) http.Handler {
+	fmt.Println("Body Limit Middleware...")
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = 1 << 20
+	}
+	routes := http.NewServeMux()
+	for pattern := range options.Routes {
+		routes.Handle(pattern, http.NotFoundHandler())
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := options.MaxBytes
+			if _, pattern := routes.Handler(r); pattern != "" {
+				limit = options.Routes[pattern]
+			}
+			if r.ContentLength > limit {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
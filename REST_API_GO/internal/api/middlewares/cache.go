@@ -0,0 +1,420 @@
+package middlewares
+
+import (
+	"bytes"
+	"container/list"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStats counts cache hits, misses and invalidations. It is published
+// with expvar as "http_cache".
+var CacheStats = expvar.NewMap("http_cache")
+
+// CacheOptions configures NewCache.
+type CacheOptions struct {
+	// Routes are the ServeMux patterns whose responses are cached, such as
+	// "GET /teachers". Only list them for responses that are the same for
+	// every client allowed to see them.
+	Routes []string
+	// MaxBytes bounds the memory held by cached responses; 32 MiB by
+	// default. The least recently used responses are evicted first.
+	MaxBytes int64
+	// MaxEntrySize is the largest response cached; 1 MiB by default.
+	MaxEntrySize int64
+	// TTL is how long a response stays fresh when its handler does not say
+	// with Cache-Control; 30 seconds by default.
+	TTL time.Duration
+}
+
+// Cache keeps successful GET responses in memory and replays them. Entries
+// are keyed on the path, the normalized query and the request headers the
+// response varies on.
+//
+// Clients may bypass the cache with "Cache-Control: no-cache" or limit the
+// age they accept with max-age, and handlers may shorten or prevent caching
+// with their own Cache-Control. With Invalidate, a successful POST, PUT,
+// PATCH or DELETE drops every cached response under the same first path
+// segment, so "PATCH /teachers/3" invalidates both "/teachers" and
+// "/teachers/3".
+type Cache struct {
+	options CacheOptions
+	routes  *http.ServeMux
+
+	mu   sync.Mutex
+	size int64
+	lru  *list.List // of *cacheEntry, most recently used first
+	// entries are indexed by primaryCacheKey, then by variantKey.
+	entries map[string]*cacheVariants
+	// generations change whenever a resource is invalidated, so responses
+	// computed before a mutation are not stored after it.
+	generations map[string]uint64
+}
+
+// cacheVariants are the responses cached for one path and query, which vary
+// on the same request headers.
+type cacheVariants struct {
+	vary     []string
+	elements map[string]*list.Element
+}
+
+type cacheEntry struct {
+	primary  string
+	key      string
+	resource string
+	status   int
+	header   http.Header
+	body     []byte
+	stored   time.Time
+	expires  time.Time
+}
+
+func (e *cacheEntry) size() int64 {
+	n := int64(len(e.key) + len(e.body))
+	for name, values := range e.header {
+		n += int64(len(name))
+		for _, v := range values {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+func NewCache(options CacheOptions) *Cache {
+	fmt.Println("Cache Middleware...")
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = 32 << 20
+	}
+	if options.MaxEntrySize <= 0 {
+		options.MaxEntrySize = 1 << 20
+	}
+	if options.TTL <= 0 {
+		options.TTL = 30 * time.Second
+	}
+	routes := http.NewServeMux()
+	for _, pattern := range options.Routes {
+		routes.Handle(pattern, http.NotFoundHandler())
+	}
+	return &Cache{
+		options:     options,
+		routes:      routes,
+		lru:         list.New(),
+		entries:     make(map[string]*cacheVariants),
+		generations: make(map[string]uint64),
+	}
+}
+
+// Invalidate drops the cached responses of a resource after a successful
+// POST, PUT, PATCH or DELETE to it. It must see every mutation, so it
+// belongs in the global chain even when Middleware only runs for some
+// routes.
+func (c *Cache) Invalidate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		rw := newResponseWritter(w, "")
+		next.ServeHTTP(rw, r)
+		if rw.status >= 200 && rw.status < 300 {
+			c.invalidate(RouteGroup(r))
+		}
+	})
+}
+
+// Middleware serves and stores the responses of the cached routes. Access
+// checks must run before it, or a cached response would skip them.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, pattern := c.routes.Handler(r); pattern == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		directives := cacheControl(r.Header)
+		if _, ok := directives["no-store"]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		primary := primaryCacheKey(r)
+		_, noCache := directives["no-cache"]
+		if !noCache {
+			maxAge := time.Duration(-1)
+			if v, ok := directives["max-age"]; ok {
+				if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+					maxAge = time.Duration(n) * time.Second
+				}
+			}
+			if e := c.lookup(primary, r, maxAge); e != nil {
+				CacheStats.Add("hits", 1)
+				e.replay(w, r)
+				return
+			}
+		}
+		CacheStats.Add("misses", 1)
+		if r.Method == http.MethodHead {
+			// There is no body to store.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		resource := RouteGroup(r)
+		c.mu.Lock()
+		generation := c.generations[resource]
+		c.mu.Unlock()
+
+		before := w.Header().Clone()
+		cw := &cacheWriter{ResponseWriter: w, status: http.StatusOK, limit: c.options.MaxEntrySize}
+		w.Header().Set("X-Cache", "MISS")
+		next.ServeHTTP(cw, r)
+		if cw.status != http.StatusOK || cw.tooLarge {
+			return
+		}
+
+		// Keep only what the handler set; the middlewares in front of the
+		// cache set their own headers on every request.
+		header := changedHeaders(before, w.Header())
+		header.Del("X-Cache")
+		if header.Get("Set-Cookie") != "" {
+			return
+		}
+		ttl := c.options.TTL
+		handler := cacheControl(header)
+		for _, name := range []string{"no-store", "no-cache", "private"} {
+			if _, ok := handler[name]; ok {
+				return
+			}
+		}
+		for _, name := range []string{"max-age", "s-maxage"} {
+			if n, err := strconv.Atoi(handler[name]); err == nil {
+				ttl = time.Duration(n) * time.Second
+			}
+		}
+		if ttl <= 0 {
+			return
+		}
+		vary := varyNames(w.Header())
+		if slices.Contains(vary, "*") {
+			return
+		}
+
+		now := time.Now()
+		c.store(generation, vary, &cacheEntry{
+			primary:  primary,
+			key:      variantKey(primary, vary, r),
+			resource: resource,
+			status:   cw.status,
+			header:   header,
+			body:     cw.body.Bytes(),
+			stored:   now,
+			expires:  now.Add(ttl),
+		})
+	})
+}
+
+// lookup returns the fresh entry for r no older than maxAge, if any. A
+// negative maxAge accepts any fresh entry.
+func (c *Cache) lookup(primary string, r *http.Request, maxAge time.Duration) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	variants, ok := c.entries[primary]
+	if !ok {
+		return nil
+	}
+	el, ok := variants.elements[variantKey(primary, variants.vary, r)]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	now := time.Now()
+	if now.After(e.expires) {
+		c.remove(el)
+		return nil
+	}
+	if maxAge >= 0 && now.Sub(e.stored) > maxAge {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+// store adds e unless its resource was invalidated since generation.
+func (c *Cache) store(generation uint64, vary []string, e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generations[e.resource] != generation {
+		return
+	}
+	// Drop the response being replaced and, if the response varies on other
+	// headers now, the variants that can no longer be found.
+	if variants, ok := c.entries[e.primary]; ok {
+		for key, el := range variants.elements {
+			if key == e.key || !slices.Equal(variants.vary, vary) {
+				c.remove(el)
+			}
+		}
+	}
+	variants, ok := c.entries[e.primary]
+	if !ok {
+		variants = &cacheVariants{vary: vary, elements: make(map[string]*list.Element)}
+		c.entries[e.primary] = variants
+	}
+	variants.elements[e.key] = c.lru.PushFront(e)
+	c.size += e.size()
+	for c.size > c.options.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	c.size -= e.size()
+	variants := c.entries[e.primary]
+	delete(variants.elements, e.key)
+	if len(variants.elements) == 0 {
+		delete(c.entries, e.primary)
+	}
+}
+
+// invalidate drops the cached responses of resource.
+func (c *Cache) invalidate(resource string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generations[resource]++
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*cacheEntry).resource == resource {
+			c.remove(el)
+		}
+		el = next
+	}
+	CacheStats.Add("invalidations", 1)
+}
+
+func (e *cacheEntry) replay(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	for name, values := range e.header {
+		// Middlewares may add to the headers; the entry must not change.
+		h[name] = slices.Clone(values)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+	h.Set("X-Cache", "HIT")
+	h.Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.body)
+	}
+}
+
+// primaryCacheKey identifies what r asks for regardless of its headers. HEAD
+// requests share the entries of GET. Query parameters are sorted by name.
+func primaryCacheKey(r *http.Request) string {
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return "GET " + r.URL.Path + "?" + r.URL.RawQuery
+	}
+	return "GET " + r.URL.Path + "?" + query.Encode()
+}
+
+// variantKey adds the values of the request headers named in vary.
+func variantKey(primary string, vary []string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(name), ", "))
+	}
+	return b.String()
+}
+
+// varyNames lists the header names in the Vary headers of h, canonicalized,
+// sorted and without duplicates.
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// cacheControl parses the Cache-Control directives in h. Directive names are
+// lower case; directives without a value map to "".
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// changedHeaders returns the headers of after that differ from before.
+func changedHeaders(before, after http.Header) http.Header {
+	changed := make(http.Header)
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			changed[name] = slices.Clone(values)
+		}
+	}
+	return changed
+}
+
+// cacheWriter records the status and body of the response as it is written,
+// giving up on the body once it grows past limit.
+type cacheWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	limit       int64
+	tooLarge    bool
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	cw.wroteHeader = true
+	if !cw.tooLarge {
+		if int64(cw.body.Len()+len(b)) > cw.limit {
+			cw.tooLarge = true
+			cw.body = bytes.Buffer{}
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,238 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingHandler answers with the request's path and query and the number
+// of requests it has handled so far, so a cached response can be told from
+// a fresh one.
+type countingHandler struct {
+	calls  int
+	header http.Header
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	for name, values := range h.header {
+		w.Header()[name] = values
+	}
+	fmt.Fprintf(w, "%s %s #%d", r.URL.RequestURI(), r.Header.Get("Accept-Language"), h.calls)
+}
+
+func newTestCache(options CacheOptions) *Cache {
+	if options.Routes == nil {
+		options.Routes = []string{"GET /teachers", "GET /teachers/{id}"}
+	}
+	return NewCache(options)
+}
+
+// get sends a GET for target through h with the given request headers,
+// given as name/value pairs.
+func get(h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Add(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+// age moves every entry of c d into the past.
+func age(c *Cache, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*cacheEntry)
+		e.stored = e.stored.Add(-d)
+		e.expires = e.expires.Add(-d)
+	}
+}
+
+func TestCacheLookup(t *testing.T) {
+	c := newTestCache(CacheOptions{})
+	next := &countingHandler{}
+	h := c.Middleware(next)
+
+	first := get(h, "/teachers?b=2&a=1")
+	if first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first response X-Cache = %q", first.Header().Get("X-Cache"))
+	}
+	// The query is normalized, so the order of its parameters does not matter.
+	second := get(h, "/teachers?a=1&b=2")
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Fatalf("second response %q %q, want a hit for %q", second.Header().Get("X-Cache"), second.Body, first.Body)
+	}
+	if next.calls != 1 {
+		t.Errorf("handler called %d times, want 1", next.calls)
+	}
+
+	if rec := get(h, "/teachers?a=1&b=2", "Cache-Control", "no-cache"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("no-cache request was served from the cache")
+	}
+	get(h, "/students")
+	get(h, "/students")
+	if next.calls != 4 {
+		t.Errorf("uncached route: handler called %d times, want 4", next.calls)
+	}
+}
+
+func TestCacheTTLAndMaxAge(t *testing.T) {
+	c := newTestCache(CacheOptions{TTL: time.Minute})
+	next := &countingHandler{}
+	h := c.Middleware(next)
+
+	get(h, "/teachers")
+	age(c, 10*time.Second)
+	if rec := get(h, "/teachers"); rec.Header().Get("X-Cache") != "HIT" || rec.Header().Get("Age") != "10" {
+		t.Fatalf("fresh entry: X-Cache %q Age %q", rec.Header().Get("X-Cache"), rec.Header().Get("Age"))
+	}
+	// The client accepts responses no older than 5 seconds.
+	if rec := get(h, "/teachers", "Cache-Control", "max-age=5"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("entry older than the request's max-age was served")
+	}
+	age(c, 2*time.Minute)
+	if rec := get(h, "/teachers"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("expired entry was served")
+	}
+	if next.calls != 3 {
+		t.Errorf("handler called %d times, want 3", next.calls)
+	}
+
+	// The handler's own max-age replaces the TTL.
+	c = newTestCache(CacheOptions{TTL: time.Minute})
+	next = &countingHandler{header: http.Header{"Cache-Control": {"max-age=600"}}}
+	h = c.Middleware(next)
+	get(h, "/teachers")
+	age(c, 5*time.Minute)
+	if rec := get(h, "/teachers"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("handler max-age=600 entry expired after 5 minutes")
+	}
+
+	next.header = http.Header{"Cache-Control": {"no-store"}}
+	get(h, "/teachers/1")
+	get(h, "/teachers/1")
+	if next.calls != 3 {
+		t.Errorf("no-store response was cached")
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	c := newTestCache(CacheOptions{})
+	next := &countingHandler{header: http.Header{"Vary": {"Accept-Language"}}}
+	h := c.Middleware(next)
+
+	en := get(h, "/teachers", "Accept-Language", "en")
+	fr := get(h, "/teachers", "Accept-Language", "fr")
+	if next.calls != 2 {
+		t.Fatalf("handler called %d times for two languages, want 2", next.calls)
+	}
+	for _, want := range []*httptest.ResponseRecorder{en, fr} {
+		language := strings.Fields(want.Body.String())[1]
+		rec := get(h, "/teachers", "Accept-Language", language)
+		if rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != want.Body.String() {
+			t.Errorf("%s: got %q %q, want a hit for %q", language, rec.Header().Get("X-Cache"), rec.Body, want.Body)
+		}
+	}
+	if next.calls != 2 {
+		t.Errorf("handler called %d times, want 2", next.calls)
+	}
+}
+
+func TestCacheReplayDoesNotShareHeaders(t *testing.T) {
+	c := newTestCache(CacheOptions{})
+	h := c.Middleware(&countingHandler{header: http.Header{"Vary": {"Accept-Language"}}})
+	get(h, "/teachers")
+
+	// A middleware in front of the cache rewriting a replayed header must
+	// not change the cached entry.
+	rewriteVary := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			if vary := w.Header()["Vary"]; len(vary) > 0 {
+				vary[0] += ", Origin"
+			}
+		})
+	}
+	get(rewriteVary(h), "/teachers")
+	if rec := get(h, "/teachers"); rec.Header().Get("Vary") != "Accept-Language" {
+		t.Errorf("cached Vary = %q, want Accept-Language", rec.Header().Values("Vary"))
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	next := &countingHandler{}
+	// Measure one entry, then allow three of them.
+	probe := newTestCache(CacheOptions{})
+	get(probe.Middleware(next), "/teachers/1")
+	c := newTestCache(CacheOptions{MaxBytes: 3*probe.size + 2})
+	h := c.Middleware(next)
+
+	for _, id := range []string{"1", "2", "3"} {
+		get(h, "/teachers/"+id)
+	}
+	get(h, "/teachers/1") // 2 is now the least recently used
+	get(h, "/teachers/4")
+	if c.size > c.options.MaxBytes {
+		t.Errorf("cache holds %d bytes, over MaxBytes %d", c.size, c.options.MaxBytes)
+	}
+	for id, want := range map[string]bool{"1": true, "2": false, "3": true, "4": true} {
+		if _, cached := c.entries["GET /teachers/"+id+"?"]; cached != want {
+			t.Errorf("/teachers/%s cached = %t, want %t", id, cached, want)
+		}
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c := newTestCache(CacheOptions{})
+	next := &countingHandler{}
+	h := c.Invalidate(c.Middleware(next))
+
+	get(h, "/teachers")
+	get(h, "/teachers/1")
+	r := httptest.NewRequest(http.MethodPatch, "/teachers/1", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	for _, target := range []string{"/teachers", "/teachers/1"} {
+		if rec := get(h, target); rec.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("%s was served from the cache after a PATCH", target)
+		}
+	}
+
+	// A failed mutation leaves the cache alone.
+	failing := c.Invalidate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Conflict", http.StatusConflict)
+	}))
+	failing.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPatch, "/teachers/1", nil))
+	if rec := get(h, "/teachers"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("failed PATCH invalidated the cache")
+	}
+}
+
+// TestCacheGeneration checks a response computed before an invalidation is
+// not stored after it.
+func TestCacheGeneration(t *testing.T) {
+	c := newTestCache(CacheOptions{})
+	calls := 0
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// A mutation completes while the list is being read.
+			c.invalidate("teachers")
+		}
+		fmt.Fprintf(w, "#%d", calls)
+	}))
+
+	get(h, "/teachers")
+	if rec := get(h, "/teachers"); rec.Header().Get("X-Cache") != "MISS" || rec.Body.String() != "#2" {
+		t.Fatalf("stale response was stored: %q %q", rec.Header().Get("X-Cache"), rec.Body)
+	}
+	if rec := get(h, "/teachers"); rec.Header().Get("X-Cache") != "HIT" || rec.Body.String() != "#2" {
+		t.Errorf("response after the invalidation was not stored: %q %q", rec.Header().Get("X-Cache"), rec.Body)
+	}
+}
@@ -0,0 +1,161 @@
+package middlewares
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Middleware wraps a handler.
+type Middleware func(http.Handler) http.Handler
+
+type namedMiddleware struct {
+	name string
+	wrap Middleware
+}
+
+// Chain is an ordered list of middlewares. They run in the order they were
+// added: the first one sees the request first and the response last.
+// Chains are immutable; Use and Extend return new ones.
+type Chain struct {
+	middlewares []namedMiddleware
+}
+
+// NewChain returns an empty chain.
+func NewChain() Chain {
+	return Chain{}
+}
+
+// Use returns the chain with m added as its innermost middleware. The name
+// identifies it in Names and in the route listing.
+func (c Chain) Use(name string, m Middleware) Chain {
+	c.middlewares = append(slices.Clip(c.middlewares), namedMiddleware{name: name, wrap: m})
+	return c
+}
+
+// Extend returns the chain followed by the middlewares of other.
+func (c Chain) Extend(other Chain) Chain {
+	c.middlewares = append(slices.Clip(c.middlewares), other.middlewares...)
+	return c
+}
+
+// Names lists the middlewares from the outermost to the innermost.
+func (c Chain) Names() []string {
+	names := make([]string, len(c.middlewares))
+	for i, m := range c.middlewares {
+		names[i] = m.name
+	}
+	return names
+}
+
+// Then wraps h in the chain.
+func (c Chain) Then(h http.Handler) http.Handler {
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		h = c.middlewares[i].wrap(h)
+	}
+	return h
+}
+
+// Router registers routes on a ServeMux in groups that share a chain, and
+// remembers which chain each route got.
+type Router struct {
+	mux    *http.ServeMux
+	global Chain
+	routes []Route
+	groups map[string]string // by pattern
+}
+
+// Route describes a registered route.
+type Route struct {
+	Pattern string
+	Group   string
+	// Middlewares is the effective chain, global middlewares first.
+	Middlewares []string
+}
+
+// NewRouter registers routes on mux. global is the chain wrapped around the
+// mux itself; it is only used to describe the routes.
+func NewRouter(mux *http.ServeMux, global Chain) *Router {
+	return &Router{mux: mux, global: global, groups: make(map[string]string)}
+}
+
+// Group returns a group whose routes run chain after the global chain.
+func (rt *Router) Group(name string, chain Chain) *Group {
+	return &Group{router: rt, name: name, chain: chain}
+}
+
+// Routes lists the registered routes in registration order.
+func (rt *Router) Routes() []Route {
+	return slices.Clone(rt.routes)
+}
+
+// GroupOf returns the group of the route r matches, or "" if it matches
+// none. Global middlewares use it to apply per-group policies before the
+// group's chain runs.
+func (rt *Router) GroupOf(r *http.Request) string {
+	_, pattern := rt.mux.Handler(r)
+	return rt.groups[pattern]
+}
+
+// Resource returns the first path segment of the pattern of the route r
+// matches, such as "students" for "GET /students/{id}", "root" for "/", or
+// "" if r matches no route. Unlike RouteGroup it only yields names the
+// routes define, whatever path the client sends.
+func (rt *Router) Resource(r *http.Request) string {
+	_, pattern := rt.mux.Handler(r)
+	if pattern == "" {
+		return ""
+	}
+	// Patterns are "[METHOD ][HOST]/PATH".
+	if _, rest, ok := strings.Cut(pattern, " "); ok {
+		pattern = rest
+	}
+	_, path, _ := strings.Cut(pattern, "/")
+	segment, _, _ := strings.Cut(path, "/")
+	if segment == "" {
+		return "root"
+	}
+	return segment
+}
+
+// PrintRoutes writes each route with its effective chain to w.
+func (rt *Router) PrintRoutes(w io.Writer) {
+	width := 0
+	for _, route := range rt.routes {
+		width = max(width, len(route.Pattern))
+	}
+	for _, route := range rt.routes {
+		fmt.Fprintf(w, "%-*s  [%s] %s\n", width, route.Pattern, route.Group, strings.Join(route.Middlewares, " -> "))
+	}
+}
+
+// Group is a set of routes sharing a chain.
+type Group struct {
+	router *Router
+	name   string
+	chain  Chain
+}
+
+// With returns a group of the same name whose routes also run m, after the
+// group's own middlewares.
+func (g *Group) With(name string, m Middleware) *Group {
+	return &Group{router: g.router, name: g.name, chain: g.chain.Use(name, m)}
+}
+
+// Handle registers h for pattern behind the group's chain.
+func (g *Group) Handle(pattern string, h http.Handler) {
+	g.router.mux.Handle(pattern, g.chain.Then(h))
+	g.router.groups[pattern] = g.name
+	g.router.routes = append(g.router.routes, Route{
+		Pattern:     pattern,
+		Group:       g.name,
+		Middlewares: append(g.router.global.Names(), g.chain.Names()...),
+	})
+}
+
+// HandleFunc registers f for pattern behind the group's chain.
+func (g *Group) HandleFunc(pattern string, f http.HandlerFunc) {
+	g.Handle(pattern, f)
+}
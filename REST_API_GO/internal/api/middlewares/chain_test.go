@@ -0,0 +1,130 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// tracing returns a middleware that appends its name to *calls before and
+// after the rest of the chain runs.
+func tracing(name string, calls *[]string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*calls = append(*calls, name+" in")
+			next.ServeHTTP(w, r)
+			*calls = append(*calls, name+" out")
+		})
+	}
+}
+
+func TestChainThenOrder(t *testing.T) {
+	var calls []string
+	chain := NewChain().
+		Use("first", tracing("first", &calls)).
+		Use("second", tracing("second", &calls))
+	h := chain.Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	want := []string{"first in", "second in", "handler", "second out", "first out"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if names := chain.Names(); !slices.Equal(names, []string{"first", "second"}) {
+		t.Errorf("Names() = %q", names)
+	}
+}
+
+func TestChainIsImmutable(t *testing.T) {
+	var calls []string
+	base := NewChain().Use("a", tracing("a", &calls)).Use("b", tracing("b", &calls))
+	// Chains built from base must not overwrite base or each other.
+	left := base.Use("left", tracing("left", &calls))
+	right := base.Use("right", tracing("right", &calls))
+	extended := base.Extend(NewChain().Use("extra", tracing("extra", &calls)))
+
+	for _, c := range []struct {
+		name  string
+		chain Chain
+		want  []string
+	}{
+		{"base", base, []string{"a", "b"}},
+		{"left", left, []string{"a", "b", "left"}},
+		{"right", right, []string{"a", "b", "right"}},
+		{"extended", extended, []string{"a", "b", "extra"}},
+	} {
+		if names := c.chain.Names(); !slices.Equal(names, c.want) {
+			t.Errorf("%s.Names() = %q, want %q", c.name, names, c.want)
+		}
+	}
+
+	calls = nil
+	base.Then(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := []string{"a in", "b in", "b out", "a out"}; !slices.Equal(calls, want) {
+		t.Errorf("base ran %q, want %q", calls, want)
+	}
+}
+
+func TestRouterRoutes(t *testing.T) {
+	noop := func(next http.Handler) http.Handler { return next }
+	mux := http.NewServeMux()
+	router := NewRouter(mux, NewChain().Use("request-id", noop))
+	public := router.Group("public", NewChain().Use("cache", noop))
+	admin := router.Group("admin", NewChain().Use("require-admin", noop))
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	public.HandleFunc("GET /teachers", ok)
+	admin.With("require-if-match", noop).HandleFunc("DELETE /teachers/{id}", ok)
+
+	want := []Route{
+		{Pattern: "GET /teachers", Group: "public", Middlewares: []string{"request-id", "cache"}},
+		{Pattern: "DELETE /teachers/{id}", Group: "admin", Middlewares: []string{"request-id", "require-admin", "require-if-match"}},
+	}
+	routes := router.Routes()
+	if !slices.EqualFunc(routes, want, func(a, b Route) bool {
+		return a.Pattern == b.Pattern && a.Group == b.Group && slices.Equal(a.Middlewares, b.Middlewares)
+	}) {
+		t.Errorf("Routes() = %+v, want %+v", routes, want)
+	}
+	for target, want := range map[string]string{
+		"DELETE /teachers/1": "admin",
+		"GET /teachers":      "public",
+		"GET /students":      "",
+	} {
+		method, path, _ := strings.Cut(target, " ")
+		if group := router.GroupOf(httptest.NewRequest(method, path, nil)); group != want {
+			t.Errorf("GroupOf(%s) = %q, want %q", target, group, want)
+		}
+	}
+
+	// Resource only yields names from the registered patterns.
+	for target, want := range map[string]string{
+		"DELETE /teachers/1":    "teachers",
+		"GET /teachers":         "teachers",
+		"GET /total/1":          "",
+		"GET /random-123/whats": "",
+	} {
+		method, path, _ := strings.Cut(target, " ")
+		if resource := router.Resource(httptest.NewRequest(method, path, nil)); resource != want {
+			t.Errorf("Resource(%s) = %q, want %q", target, resource, want)
+		}
+	}
+	public.HandleFunc("/", ok)
+	if resource := router.Resource(httptest.NewRequest(http.MethodGet, "/random-123/whats", nil)); resource != "root" {
+		t.Errorf("Resource of a path only the root route matches = %q, want root", resource)
+	}
+
+	var out bytes.Buffer
+	router.PrintRoutes(&out)
+	wantOut := "GET /teachers          [public] request-id -> cache\n" +
+		"DELETE /teachers/{id}  [admin] request-id -> require-admin -> require-if-match\n" +
+		"/                      [public] request-id -> cache\n"
+	if out.String() != wantOut {
+		t.Errorf("PrintRoutes wrote\n%s\nwant\n%s", out.String(), wantOut)
+	}
+}
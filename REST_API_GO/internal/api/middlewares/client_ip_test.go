@@ -0,0 +1,101 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesResolve(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 192.0.2.1 ", "", "2001:db8:ffff::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		remote string
+		header []string
+		want   string
+	}{
+		{"no headers", "203.0.113.7:5555", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:5555", []string{"X-Forwarded-For", "198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer with Forwarded", "203.0.113.7:5555", []string{"Forwarded", "for=198.51.100.1"}, "203.0.113.7"},
+		{"RemoteAddr without a port", "203.0.113.7", []string{"X-Forwarded-For", "198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer", "10.1.2.3:5555", []string{"X-Forwarded-For", "198.51.100.1"}, "198.51.100.1"},
+		{"trusted single host", "192.0.2.1:5555", []string{"X-Forwarded-For", "198.51.100.1"}, "198.51.100.1"},
+		{"trusted peer without headers", "10.1.2.3:5555", nil, "10.1.2.3"},
+		{"trusted IPv4-mapped peer", "[::ffff:10.1.2.3]:5555", []string{"X-Forwarded-For", "198.51.100.1"}, "198.51.100.1"},
+
+		// The walk goes right to left and stops at the first untrusted hop,
+		// so whatever a client puts on the left is never believed.
+		{"walks past trusted hops", "10.0.0.1:5555", []string{"X-Forwarded-For", "198.51.100.1, 10.0.0.3, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed left-most entry", "10.0.0.1:5555", []string{"X-Forwarded-For", "1.1.1.1, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed trusted entry", "10.0.0.1:5555", []string{"X-Forwarded-For", "10.9.9.9, 198.51.100.1"}, "198.51.100.1"},
+		{"header lines in order", "10.0.0.1:5555", []string{"X-Forwarded-For", "1.1.1.1", "X-Forwarded-For", "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"only trusted hops", "10.0.0.1:5555", []string{"X-Forwarded-For", "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"X-Forwarded-For with a port", "10.0.0.1:5555", []string{"X-Forwarded-For", "198.51.100.1:4711"}, "198.51.100.1"},
+		{"X-Forwarded-For IPv6", "10.0.0.1:5555", []string{"X-Forwarded-For", "2001:db8::17"}, "2001:db8::17"},
+		{"X-Forwarded-For bracketed IPv6 with a port", "10.0.0.1:5555", []string{"X-Forwarded-For", "[2001:db8::17]:4711"}, "2001:db8::17"},
+		{"garbage stops the walk", "10.0.0.1:5555", []string{"X-Forwarded-For", "198.51.100.1, not-an-ip, 10.0.0.2"}, "10.0.0.2"},
+
+		{"Forwarded", "10.0.0.1:5555", []string{"Forwarded", "for=198.51.100.1;proto=https;by=10.0.0.1"}, "198.51.100.1"},
+		{"Forwarded takes precedence", "10.0.0.1:5555", []string{"Forwarded", "for=198.51.100.1", "X-Forwarded-For", "198.51.100.2"}, "198.51.100.1"},
+		{"Forwarded parameter case", "10.0.0.1:5555", []string{"Forwarded", "For=198.51.100.1"}, "198.51.100.1"},
+		{"Forwarded walks past trusted hops", "10.0.0.1:5555", []string{"Forwarded", "for=1.1.1.1, for=198.51.100.1, for=10.0.0.2"}, "198.51.100.1"},
+		{"Forwarded quoted IPv4 with a port", "10.0.0.1:5555", []string{"Forwarded", `for="198.51.100.1:4711"`}, "198.51.100.1"},
+		{"Forwarded quoted IPv6 with a port", "10.0.0.1:5555", []string{"Forwarded", `for="[2001:db8::17]:4711"`}, "2001:db8::17"},
+		{"Forwarded quoted IPv6", "10.0.0.1:5555", []string{"Forwarded", `for="[2001:db8::17]"`}, "2001:db8::17"},
+		{"Forwarded trusted IPv6 hop", "10.0.0.1:5555", []string{"Forwarded", `for="[2001:db8::17]", for="[2001:db8:ffff::1]"`}, "2001:db8::17"},
+		{"Forwarded obfuscated identifier", "10.0.0.1:5555", []string{"Forwarded", "for=198.51.100.1, for=_hidden, for=10.0.0.2"}, "10.0.0.2"},
+		{"Forwarded unknown", "10.0.0.1:5555", []string{"Forwarded", "for=unknown"}, "10.0.0.1"},
+		{"Forwarded without for", "10.0.0.1:5555", []string{"Forwarded", "proto=https", "X-Forwarded-For", "198.51.100.1"}, "198.51.100.1"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = c.remote
+		for i := 0; i+1 < len(c.header); i += 2 {
+			r.Header.Add(c.header[i], c.header[i+1])
+		}
+		if got := trusted.Resolve(r); got != c.want {
+			t.Errorf("%s: Resolve = %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	// Without trusted proxies the headers are ignored.
+	var none *TrustedProxies
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := none.Resolve(r); got != "10.0.0.1" {
+		t.Errorf("nil TrustedProxies: Resolve = %q, want the peer", got)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0/8"} {
+		if _, err := ParseTrustedProxies([]string{cidr}); err == nil {
+			t.Errorf("%q: no error", cidr)
+		}
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	h := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClientIP(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if seen != "198.51.100.1" {
+		t.Errorf("ClientIP behind RealIP = %q", seen)
+	}
+	if got := ClientIP(r); got != "10.0.0.1" {
+		t.Errorf("ClientIP without RealIP = %q, want the peer", got)
+	}
+}
@@ -1,43 +1,379 @@
 package middlewares
 
 import (
+	"bufio"
 	"compress/gzip"
+	"compress/zlib"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings Compression can apply.
+const (
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
 )
 
+// CompressionOptions configures CompressionWithOptions.
+type CompressionOptions struct {
+	// Encodings are the codings offered, most preferred first; the client's
+	// q-values take precedence over this order. The default is brotli, zstd,
+	// gzip and deflate.
+	Encodings []string
+	// MinSize is the smallest body, in bytes, worth compressing; 1024 by
+	// default. Smaller bodies are sent as they are.
+	MinSize int
+	// SkipContentTypes are media types that are already compressed. An entry
+	// ending in "/" matches the whole type, e.g. "video/". The default covers
+	// common image, audio, video, archive and font formats.
+	SkipContentTypes []string
+}
+
+var defaultSkipContentTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"audio/", "video/",
+	"application/zip", "application/gzip", "application/x-gzip",
+	"application/zstd", "application/x-brotli", "application/x-7z-compressed",
+	"font/woff", "font/woff2",
+}
+
+// Compression compresses responses with the default CompressionOptions.
 func Compression(next http.Handler) http.Handler {
+	return CompressionWithOptions(CompressionOptions{})(next)
+}
+
+// CompressionWithOptions compresses response bodies with the coding the
+// client prefers according to its Accept-Encoding header. Bodies are
+// buffered until MinSize bytes have been written, so small responses go out
+// uncompressed and with their Content-Length intact.
+func CompressionWithOptions(options CompressionOptions) func(http.Handler) http.Handler {
 	fmt.Println("Compression Middleware...")
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("---Compression Middleware start---")
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, r)
-			return
-		}
-		// Set the header to indicate that the response is compressed
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer func(gz *gzip.Writer) {
-			err := gz.Close()
-			if err != nil {
+	if options.Encodings == nil {
+		options.Encodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip, EncodingDeflate}
+	}
+	if options.MinSize <= 0 {
+		options.MinSize = 1024
+	}
+	if options.SkipContentTypes == nil {
+		options.SkipContentTypes = defaultSkipContentTypes
+	}
+	for _, encoding := range options.Encodings {
+		if encoders[encoding] == nil {
+			panic(fmt.Sprintf("compression: unsupported encoding %q", encoding))
+		}
+	}
 
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The body depends on Accept-Encoding whether or not this
+			// particular response ends up compressed.
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), options.Encodings)
+			if encoding == "" || r.Method == http.MethodHead || bypassCompression(r) {
+				next.ServeHTTP(w, r)
+				return
 			}
-		}(gz)
 
-		// Wrap the ResponseWriter to capture the status code
-		w = &gzipResponseWriter{ResponseWriter: w, Writter: gz}
+			cw := &compressWriter{ResponseWriter: w, options: &options, encoding: encoding, status: http.StatusOK}
+			next.ServeHTTP(cw, r)
+			if err := cw.Close(); err != nil {
+				slog.ErrorContext(r.Context(), "Error compressing response", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		})
+	}
+}
 
-		next.ServeHTTP(w, r)
-		fmt.Println("---Compression Middleware end---")
-	})
+// bypassCompression reports whether the response to r is a connection that
+// is taken over (a WebSocket or other protocol upgrade) or an event stream,
+// whose events must reach the client as soon as they are flushed.
+func bypassCompression(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.Contains(accept, "text/event-stream") {
+			return true
+		}
+	}
+	return false
 }
 
-type gzipResponseWriter struct {
+// negotiateEncoding picks the coding in offered with the highest q-value in
+// the Accept-Encoding header, breaking ties by the order of offered. It
+// returns "" when the body should be sent as it is.
+func negotiateEncoding(header string, offered []string) string {
+	if header == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-gzip" {
+			name = EncodingGzip
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(key) != "q" {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && v >= 0 && v <= 1 {
+				q = v
+			}
+		}
+		if name != "" {
+			weights[name] = q
+		}
+	}
+	weight := func(name string) float64 {
+		if q, ok := weights[name]; ok {
+			return q
+		}
+		return weights["*"]
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range offered {
+		if q := weight(encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	// identity is acceptable unless explicitly refused, and wins if the
+	// client prefers it over every coding.
+	if q, ok := weights["identity"]; ok && q > bestQ {
+		return ""
+	}
+	return best
+}
+
+// encoder is what the compress packages' writers have in common.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders hold reusable encoders per coding; allocating a fresh compressor
+// for every response dominates the cost of small ones.
+var encoders = map[string]*sync.Pool{
+	EncodingGzip: {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+	// HTTP's deflate is zlib-wrapped (RFC 9110, section 8.4.1.2); raw
+	// DEFLATE does not decode in browsers or curl.
+	EncodingDeflate: {New: func() any {
+		return zlib.NewWriter(nil)
+	}},
+	EncodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(nil, 5)
+	}},
+	EncodingZstd: {New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+// compressWriter buffers the start of the body until it knows whether the
+// response is worth compressing, then either compresses or passes through.
+// It supports flushing, hijacking and http.ResponseController, so streaming
+// handlers work behind it.
+type compressWriter struct {
 	http.ResponseWriter
-	Writter *gzip.Writer
+	options  *CompressionOptions
+	encoding string
+	status   int
+
+	decided bool
+	buf     []byte
+	enc     encoder // nil when passing through
+	// streaming is set once the handler flushes; a streamed body is
+	// compressed however small its first chunk.
+	streaming bool
+	hijacked  bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		return
+	}
+	if code >= 100 && code < 200 {
+		// Informational responses go straight out; the final one follows.
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+	if !bodyAllowed(code) {
+		cw.decide()
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.options.MinSize {
+			return len(b), nil
+		}
+		cw.decide()
+		if err := cw.flushBuffer(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, compressed or not, once the status and enough of
+// the body are known.
+func (cw *compressWriter) decide() {
+	cw.decided = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && bodyAllowed(cw.status) && len(cw.buf) > 0 {
+		// net/http would sniff the compressed bytes otherwise.
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if cw.compressible() {
+		h.Set("Content-Encoding", cw.encoding)
+		// The handler's length is that of the uncompressed body.
+		h.Del("Content-Length")
+		cw.enc = encoders[cw.encoding].Get().(encoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if !bodyAllowed(cw.status) || cw.status == http.StatusPartialContent {
+		return false
+	}
+	if len(cw.buf) < cw.options.MinSize && !cw.streaming {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if length, err := strconv.Atoi(h.Get("Content-Length")); err == nil && length < cw.options.MinSize {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	for _, skip := range cw.options.SkipContentTypes {
+		if mediaType == skip || strings.HasSuffix(skip, "/") && strings.HasPrefix(mediaType, skip) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *compressWriter) flushBuffer() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// FlushError sends the headers and everything written so far to the client,
+// compressing what is buffered first. http.ResponseController calls it.
+func (cw *compressWriter) FlushError() error {
+	if cw.hijacked {
+		return http.ErrHijacked
+	}
+	if !cw.decided {
+		cw.streaming = true
+		cw.decide()
+	}
+	if err := cw.flushBuffer(); err != nil {
+		return err
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Flush implements http.Flusher.
+func (cw *compressWriter) Flush() {
+	_ = cw.FlushError()
+}
+
+// Hijack implements http.Hijacker. Nothing buffered is sent; the handler owns
+// the connection from here on.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(cw.ResponseWriter).Hijack()
+	if err == nil {
+		cw.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// ReadFrom implements io.ReaderFrom, letting an uncompressed body be copied
+// by the underlying writer, e.g. with sendfile.
+func (cw *compressWriter) ReadFrom(src io.Reader) (int64, error) {
+	if cw.decided && cw.enc == nil {
+		return io.Copy(cw.ResponseWriter, src)
+	}
+	return io.Copy(writerOnly{cw}, src)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// writerOnly hides ReadFrom so io.Copy does not recurse into it.
+type writerOnly struct {
+	io.Writer
+}
+
+// Close writes out whatever is still buffered and finishes the compressed
+// stream, returning the encoder to its pool.
+func (cw *compressWriter) Close() error {
+	if cw.hijacked {
+		return nil
+	}
+	if !cw.decided {
+		cw.decide()
+	}
+	err := cw.flushBuffer()
+	if cw.enc != nil {
+		if closeErr := cw.enc.Close(); err == nil {
+			err = closeErr
+		}
+		cw.enc.Reset(nil)
+		encoders[cw.encoding].Put(cw.enc)
+		cw.enc = nil
+	}
+	return err
 }
 
-func (g *gzipResponseWriter) Write(b []byte) (int, error) {
-	return g.Writter.Write(b)
+// bodyAllowed reports whether a response with the given status has a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
 }
@@ -0,0 +1,148 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	all := []string{EncodingBrotli, EncodingZstd, EncodingGzip, EncodingDeflate}
+	for _, c := range []struct {
+		header  string
+		offered []string
+		want    string
+	}{
+		{"", all, ""},
+		{"gzip", all, EncodingGzip},
+		{"x-gzip", all, EncodingGzip},
+		{"gzip, deflate", all, EncodingGzip},
+		{"deflate, gzip", all, EncodingGzip}, // ties go by the server's order
+		{"gzip;q=0.5, br", all, EncodingBrotli},
+		{"gzip;q=1, br;q=0.5", all, EncodingGzip},
+		{"GZIP; Q=0.8", all, EncodingGzip},
+		{"gzip;q=0", all, ""},
+		{"compress", all, ""},
+		{"*", all, EncodingBrotli},
+		{"*", []string{EncodingGzip, EncodingDeflate}, EncodingGzip},
+		{"*;q=0.5, deflate", []string{EncodingGzip, EncodingDeflate}, EncodingDeflate},
+		{"*;q=0", all, ""},
+		{"*, br;q=0", all, EncodingZstd},
+		{"gzip, identity;q=0", all, EncodingGzip},
+		{"identity;q=0, *", all, EncodingBrotli},
+		{"identity, gzip;q=0.5", all, ""},
+		{"gzip;q=2", all, EncodingGzip}, // invalid q-values count as 1
+	} {
+		if got := negotiateEncoding(c.header, c.offered); got != c.want {
+			t.Errorf("negotiateEncoding(%q, %q) = %q, want %q", c.header, c.offered, got, c.want)
+		}
+	}
+}
+
+// decoders undo each coding the way clients do.
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	EncodingGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	EncodingDeflate: func(r io.Reader) (io.Reader, error) {
+		return zlib.NewReader(r)
+	},
+	EncodingBrotli: func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	EncodingZstd: func(r io.Reader) (io.Reader, error) {
+		return zstd.NewReader(r)
+	},
+}
+
+// respond returns a handler answering with body and the given headers.
+func respond(body string, header ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i+1 < len(header); i += 2 {
+			w.Header().Set(header[i], header[i+1])
+		}
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+func TestCompression(t *testing.T) {
+	body := strings.Repeat(`{"firstName":"Ada","lastName":"Lovelace"},`, 100)
+	h := Compression(respond(body, "Content-Type", "application/json", "Content-Length", strconv.Itoa(len(body))))
+
+	for encoding, decode := range decoders {
+		rec := get(h, "/teachers", "Accept-Encoding", encoding)
+		if got := rec.Header().Get("Content-Encoding"); got != encoding {
+			t.Errorf("%s: Content-Encoding = %q", encoding, got)
+			continue
+		}
+		if got := rec.Header().Get("Content-Length"); got != "" {
+			t.Errorf("%s: the uncompressed Content-Length %s was kept", encoding, got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q", encoding, got)
+		}
+		if rec.Body.Len() >= len(body) {
+			t.Errorf("%s: %d bytes compressed to %d", encoding, len(body), rec.Body.Len())
+		}
+		dr, err := decode(rec.Body)
+		if err != nil {
+			t.Errorf("%s: %v", encoding, err)
+			continue
+		}
+		decoded, err := io.ReadAll(dr)
+		if err != nil || string(decoded) != body {
+			t.Errorf("%s: decoded %d bytes, %v", encoding, len(decoded), err)
+		}
+	}
+}
+
+func TestCompressionPassthrough(t *testing.T) {
+	small := `{"id":1}`
+	large := strings.Repeat("a", 4096)
+	for _, c := range []struct {
+		name     string
+		h        http.Handler
+		accept   string
+		encoding string
+		length   string
+	}{
+		{"below MinSize", respond(small, "Content-Type", "application/json", "Content-Length", strconv.Itoa(len(small))), "gzip", "", strconv.Itoa(len(small))},
+		{"compressed type", respond(large, "Content-Type", "image/png"), "gzip", "", ""},
+		{"already encoded", respond(large, "Content-Encoding", "gzip"), "br", "gzip", ""},
+		{"no Accept-Encoding", respond(large), "", "", ""},
+		{"only identity", respond(large), "identity", "", ""},
+	} {
+		rec := get(Compression(c.h), "/", "Accept-Encoding", c.accept)
+		if got := rec.Header().Get("Content-Encoding"); got != c.encoding {
+			t.Errorf("%s: Content-Encoding = %q, want %q", c.name, got, c.encoding)
+		}
+		if got := rec.Header().Get("Content-Length"); got != c.length {
+			t.Errorf("%s: Content-Length = %q, want %q", c.name, got, c.length)
+		}
+		if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q, want Accept-Encoding", c.name, got)
+		}
+		if rec.Body.Len() != len(large) && rec.Body.String() != small {
+			t.Errorf("%s: body changed to %d bytes", c.name, rec.Body.Len())
+		}
+	}
+
+	// The MinSize option moves the threshold.
+	h := CompressionWithOptions(CompressionOptions{MinSize: 4})(respond(small))
+	if rec := get(h, "/", "Accept-Encoding", "gzip"); rec.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Errorf("MinSize 4: %d byte body not compressed", len(small))
+	}
+}
+
+func TestCompressionNoBody(t *testing.T) {
+	h := Compression(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	rec := get(h, "/", "Accept-Encoding", "gzip")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+		t.Errorf("204: got %d Content-Encoding %q with %d bytes", rec.Code, rec.Header().Get("Content-Encoding"), rec.Body.Len())
+	}
+}
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey string
+
+const (
+	userKey      contextKey = "user"
+	clientIPKey  contextKey = "clientIP"
+	apiKeyKey    contextKey = "apiKey"
+	cspNonceKey  contextKey = "cspNonce"
+	logEntryKey  contextKey = "logEntry"
+	requestIDKey contextKey = "requestID"
+)
+
+// WithUser returns a copy of ctx carrying the authenticated user's name.
+// It must be called on the request's own goroutine, ahead of Timeout, since
+// the access log reads the user once the handler chain returns.
+func WithUser(ctx context.Context, user string) context.Context {
+	// The access log runs before authentication and only sees the request
+	// it was given, so tell it who the user turned out to be.
+	if entry, ok := ctx.Value(logEntryKey).(*accessLogEntry); ok {
+		entry.user = user
+	}
+	return context.WithValue(ctx, userKey, user)
+}
+
+// User returns the authenticated user of r, or "" for anonymous requests.
+func User(r *http.Request) string {
+	user, _ := r.Context().Value(userKey).(string)
+	return user
+}
+
+// RequestID returns the ID RequestIDs gave r, falling back to the client
+// supplied X-Request-ID when the middleware did not run.
+func RequestID(r *http.Request) string {
+	if id, ok := r.Context().Value(requestIDKey).(string); ok {
+		return id
+	}
+	return r.Header.Get("X-Request-ID")
+}
+
+// CSPNonce returns the nonce the Content-Security-Policy of r allows scripts
+// and styles to carry, for templates to put in their nonce attributes. It is
+// "" when the policy does not use CSPNonceSource.
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey).(string)
+	return nonce
+}
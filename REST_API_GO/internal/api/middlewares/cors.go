@@ -1,47 +1,179 @@
 package middlewares
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// allowedOrigins is a list of allowed origins for CORS requests.
-var allowedOrigins = []string{
-	"https://localhost:3000",
+// CorsOptions configures CorsWithOptions.
+type CorsOptions struct {
+	// AllowedOrigins are exact origins such as "https://app.example.com",
+	// wildcard subdomains such as "https://*.example.com", or "*" for any.
+	// "*" cannot be combined with AllowCredentials.
+	AllowedOrigins []string
+	// AllowedOriginPatterns are regular expressions matched against the
+	// whole origin, e.g. `https://pr-\d+\.preview\.example\.com`.
+	AllowedOriginPatterns []string
+	// AllowedMethods may be requested in a preflight.
+	AllowedMethods []string
+	// AllowedHeaders may be requested in a preflight; "*" allows any.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read.
+	ExposedHeaders []string
+	// AllowCredentials lets the browser send cookies and authorization.
+	AllowCredentials bool
+	// MaxAge is how long the browser may cache a preflight.
+	MaxAge time.Duration
 }
 
+// DefaultCorsOptions only allows the API's own origin.
+var DefaultCorsOptions = CorsOptions{
+	AllowedOrigins:   []string{"https://localhost:3000"},
+	AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+	AllowedHeaders:   []string{"Content-Type", "Content-Encoding", "Authorization", "If-Match", "Idempotency-Key", "X-API-Key"},
+	ExposedHeaders:   []string{"ETag", "Location", "Idempotent-Replayed", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"},
+	AllowCredentials: true,
+	MaxAge:           time.Hour,
+}
+
+// Cors applies DefaultCorsOptions.
 func Cors(next http.Handler) http.Handler {
+	cors, err := CorsWithOptions(DefaultCorsOptions)
+	if err != nil {
+		panic(err)
+	}
+	return cors(next)
+}
+
+// CorsWithOptions answers preflight requests and adds the CORS headers to
+// requests from allowed origins. Requests from other origins are rejected
+// with 403; requests without an Origin header, such as those from curl or
+// other servers, are not cross-origin and pass through untouched.
+//
+// Allowing any origin together with credentials would let every site act
+// with the user's cookies, so that combination is an error.
+func CorsWithOptions(options CorsOptions) (func(http.Handler) http.Handler, error) {
 	fmt.Println("Cors Middleware...")
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("---Cors Middleware start---")
-		origin := r.Header.Get("Origin")
-		fmt.Print(origin)
-
-		if isOriginAllowed(origin) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			http.Error(w, "CORS not allowed", http.StatusForbidden)
-			return
+	if options.AllowCredentials && slices.Contains(options.AllowedOrigins, "*") {
+		return nil, errors.New(`CORS origin "*" cannot be combined with credentials`)
+	}
+	c := &cors{options: options}
+	for _, pattern := range options.AllowedOriginPatterns {
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CORS origin pattern %q: %w", pattern, err)
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Expose-Headers", "Authorization")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Max-Age", "3600") // 24 hours
+		c.patterns = append(c.patterns, re)
+	}
+	for _, header := range options.AllowedHeaders {
+		if header == "*" {
+			c.anyHeader = true
+		}
+		c.headers = append(c.headers, strings.ToLower(header))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Responses differ by origin, so caches must key on it.
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !c.originAllowed(origin) {
+				http.Error(w, "CORS not allowed", http.StatusForbidden)
+				return
+			}
+			if preflight {
+				c.preflight(w, r, origin)
+				return
+			}
+			c.setOrigin(w, origin)
+			if len(options.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+type cors struct {
+	options   CorsOptions
+	patterns  []*regexp.Regexp
+	headers   []string // lower case
+	anyHeader bool
+}
 
-		if r.Method == http.MethodOptions {
-			// Preflight request, respond with 200 OK
-			w.WriteHeader(http.StatusOK)
+func (c *cors) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !slices.Contains(c.options.AllowedMethods, method) {
+		http.Error(w, "CORS method not allowed", http.StatusForbidden)
+		return
+	}
+	requested := strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",")
+	for _, header := range requested {
+		header = strings.ToLower(strings.TrimSpace(header))
+		if header != "" && !c.anyHeader && !slices.Contains(c.headers, header) {
+			http.Error(w, "CORS header not allowed: "+header, http.StatusForbidden)
 			return
 		}
-		next.ServeHTTP(w, r)
-		fmt.Println("---Cors Middleware end---")
-	})
+	}
+
+	c.setOrigin(w, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.options.AllowedMethods, ", "))
+	if c.anyHeader {
+		// "*" is taken literally when credentials are allowed.
+		h.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+	} else if len(c.options.AllowedHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(c.options.AllowedHeaders, ", "))
+	}
+	if c.options.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.options.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func isOriginAllowed(origin string) bool {
-	for _, allowedOrigin := range allowedOrigins {
-		if origin == allowedOrigin {
+// setOrigin allows origin to read the response.
+func (c *cors) setOrigin(w http.ResponseWriter, origin string) {
+	if slices.Contains(c.options.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if c.options.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (c *cors) originAllowed(origin string) bool {
+	for _, allowed := range c.options.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok && len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+			// The wildcard stands for subdomains only, not a port or path.
+			sub := origin[len(prefix) : len(origin)-len(suffix)]
+			if !strings.ContainsAny(sub, ":/") {
+				return true
+			}
+		}
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(origin) {
 			return true
 		}
 	}
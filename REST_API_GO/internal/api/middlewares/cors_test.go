@@ -0,0 +1,143 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newCors(t *testing.T, options CorsOptions) http.Handler {
+	t.Helper()
+	cors, err := CorsWithOptions(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handled", "yes")
+	}))
+}
+
+// preflight sends an OPTIONS request for method from origin, requesting the
+// given headers.
+func preflight(h http.Handler, origin, method, headers string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodOptions, "/teachers", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestCorsPreflight(t *testing.T) {
+	h := newCors(t, CorsOptions{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.example.org"},
+		AllowedOriginPatterns: []string{`https://pr-\d+\.preview\.example\.net`},
+		AllowedMethods:        []string{"GET", "PATCH"},
+		AllowedHeaders:        []string{"Content-Type", "If-Match"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+	})
+
+	for _, c := range []struct {
+		name    string
+		origin  string
+		method  string
+		headers string
+		status  int
+	}{
+		{"allowed", "https://app.example.com", "PATCH", "content-type, If-Match", http.StatusNoContent},
+		{"no headers requested", "https://app.example.com", "GET", "", http.StatusNoContent},
+		{"unknown origin", "https://evil.example", "GET", "", http.StatusForbidden},
+		{"origin with another scheme", "http://app.example.com", "GET", "", http.StatusForbidden},
+		{"method not allowed", "https://app.example.com", "DELETE", "", http.StatusForbidden},
+		{"header not allowed", "https://app.example.com", "PATCH", "Content-Type, X-Secret", http.StatusForbidden},
+		{"wildcard subdomain", "https://a.example.org", "GET", "", http.StatusNoContent},
+		{"nested wildcard subdomain", "https://a.b.example.org", "GET", "", http.StatusNoContent},
+		{"wildcard without a subdomain", "https://example.org", "GET", "", http.StatusForbidden},
+		{"wildcard with a port", "https://a.example.org:8443", "GET", "", http.StatusForbidden},
+		{"wildcard suffix lookalike", "https://a.evilexample.org", "GET", "", http.StatusForbidden},
+		{"wildcard standing for a port", "https://a:1.example.org", "GET", "", http.StatusForbidden},
+		{"pattern", "https://pr-42.preview.example.net", "GET", "", http.StatusNoContent},
+		{"pattern is anchored", "https://pr-42.preview.example.net.evil.example", "GET", "", http.StatusForbidden},
+	} {
+		rec := preflight(h, c.origin, c.method, c.headers)
+		if rec.Code != c.status {
+			t.Errorf("%s: got %d, want %d", c.name, rec.Code, c.status)
+			continue
+		}
+		if rec.Header().Get("X-Handled") != "" {
+			t.Errorf("%s: the preflight reached the handler", c.name)
+		}
+		allowOrigin := rec.Header().Get("Access-Control-Allow-Origin")
+		if c.status != http.StatusNoContent {
+			if allowOrigin != "" {
+				t.Errorf("%s: rejected preflight allows origin %q", c.name, allowOrigin)
+			}
+			continue
+		}
+		h := rec.Header()
+		if allowOrigin != c.origin || h.Get("Access-Control-Allow-Credentials") != "true" ||
+			h.Get("Access-Control-Allow-Methods") != "GET, PATCH" || h.Get("Access-Control-Allow-Headers") != "Content-Type, If-Match" ||
+			h.Get("Access-Control-Max-Age") != "600" {
+			t.Errorf("%s: headers %v", c.name, h)
+		}
+		if vary := h.Values("Vary"); !slices.Equal(vary, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}) {
+			t.Errorf("%s: Vary = %q", c.name, vary)
+		}
+	}
+}
+
+func TestCorsRequests(t *testing.T) {
+	h := newCors(t, CorsOptions{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET"},
+		ExposedHeaders: []string{"ETag"},
+	})
+
+	// Without an Origin the request is not cross-origin.
+	rec := get(h, "/teachers")
+	if rec.Header().Get("X-Handled") != "yes" || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "Origin" {
+		t.Errorf("same-origin request: %v", rec.Header())
+	}
+	// A plain OPTIONS request is not a preflight either.
+	r := httptest.NewRequest(http.MethodOptions, "/teachers", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Header().Get("X-Handled") != "yes" {
+		t.Error("OPTIONS without Access-Control-Request-Method did not reach the handler")
+	}
+
+	rec = get(h, "/teachers", "Origin", "https://app.example.com")
+	if rec.Header().Get("X-Handled") != "yes" || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		rec.Header().Get("Access-Control-Expose-Headers") != "ETag" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("allowed origin: %v", rec.Header())
+	}
+	if rec := get(h, "/teachers", "Origin", "https://evil.example"); rec.Code != http.StatusForbidden || rec.Header().Get("X-Handled") != "" {
+		t.Errorf("other origin: got %d", rec.Code)
+	}
+}
+
+func TestCorsAnyOrigin(t *testing.T) {
+	if _, err := CorsWithOptions(CorsOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Error(`"*" with credentials: no error`)
+	}
+
+	h := newCors(t, CorsOptions{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, AllowedHeaders: []string{"*"}})
+	rec := preflight(h, "https://anywhere.example", "GET", "X-Custom, Content-Type")
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" ||
+		rec.Header().Get("Access-Control-Allow-Headers") != "X-Custom, Content-Type" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("any origin and header: got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCorsInvalidPattern(t *testing.T) {
+	if _, err := CorsWithOptions(CorsOptions{AllowedOriginPatterns: []string{"("}}); err == nil {
+		t.Error("invalid pattern: no error")
+	}
+}
@@ -0,0 +1,161 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DecompressionOptions configures Decompression. Exceeding any of the limits
+// fails the handler's read of the body with an *http.MaxBytesError, which
+// the handlers answer with 413.
+type DecompressionOptions struct {
+	// MaxCompressedSize caps the body as sent; 10 MiB by default.
+	MaxCompressedSize int64
+	// MaxDecompressedSize caps the body once decoded; 100 MiB by default.
+	// Set it to the body limit, so a small compressed body cannot grow past
+	// what the handlers accept.
+	MaxDecompressedSize int64
+	// Routes override MaxDecompressedSize per ServeMux pattern such as
+	// "POST /teachers/import", like BodyLimitOptions.Routes.
+	Routes map[string]int64
+	// MaxRatio caps how many times larger than the bytes received so far the
+	// decoded body may grow; 100 by default. Only bodies past ratioGrace are
+	// checked, since a small body of repeated characters compresses far
+	// better than that and is harmless.
+	MaxRatio int64
+}
+
+const ratioGrace = 1 << 20
+
+// Decompression transparently decodes request bodies sent with a gzip,
+// deflate, br or zstd Content-Encoding, so handlers always read the plain
+// body. As in HTTP, deflate is zlib-wrapped data (RFC 9110, section
+// 8.4.1.2). Any other coding is rejected with 415.
+func Decompression(options DecompressionOptions) func(http.Handler) http.Handler {
+	fmt.Println("Decompression Middleware...")
+	if options.MaxCompressedSize <= 0 {
+		options.MaxCompressedSize = 10 << 20
+	}
+	if options.MaxDecompressedSize <= 0 {
+		options.MaxDecompressedSize = 100 << 20
+	}
+	if options.MaxRatio <= 0 {
+		options.MaxRatio = 100
+	}
+	routes := http.NewServeMux()
+	for pattern := range options.Routes {
+		routes.Handle(pattern, http.NotFoundHandler())
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			coding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+			if coding == "" || coding == "identity" || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limit := options.MaxDecompressedSize
+			if _, pattern := routes.Handler(r); pattern != "" {
+				limit = options.Routes[pattern]
+			}
+			compressed := &countingReader{r: http.MaxBytesReader(w, r.Body, options.MaxCompressedSize)}
+			var decoded io.Reader
+			var closeDecoder func()
+			switch coding {
+			case EncodingGzip, "x-gzip":
+				gz, err := gzip.NewReader(compressed)
+				if err != nil {
+					BodyError(w, err, "Invalid gzip request body")
+					return
+				}
+				decoded = gz
+			case EncodingDeflate:
+				zr, err := zlib.NewReader(compressed)
+				if err != nil {
+					BodyError(w, err, "Invalid deflate request body")
+					return
+				}
+				decoded, closeDecoder = zr, func() { _ = zr.Close() }
+			case EncodingBrotli:
+				decoded = brotli.NewReader(compressed)
+			case EncodingZstd:
+				zr, err := zstd.NewReader(compressed,
+					zstd.WithDecoderConcurrency(1),
+					zstd.WithDecoderMaxMemory(uint64(limit)))
+				if err != nil {
+					http.Error(w, "Invalid zstd request body", http.StatusBadRequest)
+					return
+				}
+				decoded, closeDecoder = zr, zr.Close
+			default:
+				// Tell the client what it may use instead (RFC 7694).
+				w.Header().Set("Accept-Encoding", "gzip, deflate, br, zstd")
+				http.Error(w, "Unsupported Content-Encoding "+coding, http.StatusUnsupportedMediaType)
+				return
+			}
+			if closeDecoder != nil {
+				defer closeDecoder()
+			}
+
+			r.Body = &decompressedBody{
+				decoded:    decoded,
+				compressed: compressed,
+				limit:      limit,
+				maxRatio:   options.MaxRatio,
+				closer:     r.Body,
+			}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decompressedBody enforces the decoded size and ratio limits while the
+// handler reads the body.
+type decompressedBody struct {
+	decoded    io.Reader
+	compressed *countingReader
+	limit      int64
+	maxRatio   int64
+	closer     io.Closer
+	n          int64
+}
+
+func (d *decompressedBody) Read(p []byte) (int, error) {
+	n, err := d.decoded.Read(p)
+	d.n += int64(n)
+	if d.n > d.limit {
+		return n, &http.MaxBytesError{Limit: d.limit}
+	}
+	if limit := d.compressed.n * d.maxRatio; d.n > ratioGrace && d.n > limit {
+		// Reported like a size limit: the body outgrew what was allowed
+		// for the bytes received.
+		return n, &http.MaxBytesError{Limit: limit}
+	}
+	return n, err
+}
+
+func (d *decompressedBody) Close() error {
+	return d.closer.Close()
+}
@@ -0,0 +1,112 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echo answers with the request body it read, or the error reading it.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		BodyError(w, err, "Invalid request body")
+		return
+	}
+	_, _ = w.Write(body)
+})
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func deflated(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func decompress(h http.Handler, target, coding string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	r.Header.Set("Content-Encoding", coding)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestDecompression(t *testing.T) {
+	const body = `{"firstName":"Ada","lastName":"Lovelace"}`
+	h := Decompression(DecompressionOptions{})(echo)
+
+	for _, c := range []struct {
+		coding string
+		body   []byte
+	}{
+		{"gzip", gzipped(t, body)},
+		{"x-gzip", gzipped(t, body)},
+		{"deflate", deflated(t, body)},
+		{"identity", []byte(body)},
+	} {
+		rec := decompress(h, "/teachers", c.coding, c.body)
+		if rec.Code != http.StatusOK || rec.Body.String() != body {
+			t.Errorf("%s: got %d %q, want %q", c.coding, rec.Code, rec.Body, body)
+		}
+	}
+
+	rec := decompress(h, "/teachers", "compress", []byte(body))
+	if rec.Code != http.StatusUnsupportedMediaType || rec.Header().Get("Accept-Encoding") == "" {
+		t.Errorf("unknown coding: got %d Accept-Encoding %q, want 415 listing the codings", rec.Code, rec.Header().Get("Accept-Encoding"))
+	}
+	if rec := decompress(h, "/teachers", "gzip", []byte(body)); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid gzip: got %d, want 400", rec.Code)
+	}
+}
+
+func TestDecompressionLimits(t *testing.T) {
+	h := Decompression(DecompressionOptions{
+		MaxDecompressedSize: 1 << 10,
+		Routes:              map[string]int64{"POST /teachers/import": 1 << 20},
+	})(echo)
+	// 64 KiB of one character compresses to well under a kilobyte.
+	large := strings.Repeat("a", 64<<10)
+
+	if rec := decompress(h, "/teachers", "gzip", gzipped(t, large)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("body over the limit: got %d, want 413", rec.Code)
+	}
+	if rec := decompress(h, "/teachers", "deflate", deflated(t, large)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("deflate body over the limit: got %d, want 413", rec.Code)
+	}
+	if rec := decompress(h, "/teachers/import", "gzip", gzipped(t, large)); rec.Code != http.StatusOK || rec.Body.Len() != len(large) {
+		t.Errorf("body within the route's limit: got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+}
+
+// TestDecompressionRatio checks a body growing past MaxRatio times its
+// compressed size is cut off once it is past the grace size.
+func TestDecompressionRatio(t *testing.T) {
+	h := Decompression(DecompressionOptions{MaxRatio: 10})(echo)
+	if rec := decompress(h, "/teachers", "gzip", gzipped(t, strings.Repeat("a", 2*ratioGrace))); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d, want 413", rec.Code)
+	}
+}
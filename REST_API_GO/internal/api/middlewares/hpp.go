@@ -1,81 +1,395 @@
 package middlewares
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 )
 
+// Policies for a parameter that appears more than once, set through
+// HTTPOptions.Duplicates.
+const (
+	// DuplicateLast keeps the last value. It is the default.
+	DuplicateLast = "last"
+	// DuplicateFirst keeps the first value.
+	DuplicateFirst = "first"
+	// DuplicateReject answers the request with 400.
+	DuplicateReject = "reject"
+	// DuplicateArray keeps every value; JSON duplicates become an array.
+	DuplicateArray = "array"
+)
+
+// maxFormMemory is how much of a multipart body is held in memory; the rest
+// of its files go to temporary files.
+const maxFormMemory = 32 << 20
+
+// maxJSONDepth is how deeply the objects and arrays of a JSON body may nest.
+const maxJSONDepth = 64
+
+var errJSONTooDeep = fmt.Errorf("JSON body nested more than %d levels deep", maxJSONDepth)
+
 type HTTPOptions struct {
-	CheckQuery                  bool
+	CheckQuery bool
+	// CheckBody checks url encoded and multipart forms and JSON objects sent
+	// with POST, PUT and PATCH.
 	CheckBody                   bool
 	CheckBodyOnlyForContentType string
-	Whitelist                   []string
+	// MaxBodySize is the largest url encoded or JSON body checked, 1 MiB by
+	// default. Larger bodies are passed on unchecked rather than buffered.
+	MaxBodySize int64
+	// QueryWhitelist and BodyWhitelist are the only parameters kept in the
+	// query and the body; the others are dropped. For JSON bodies they apply
+	// to the top level keys. An empty list keeps every parameter.
+	QueryWhitelist []string
+	BodyWhitelist  []string
+	// Duplicates sets the duplicate policy per parameter; DefaultDuplicate
+	// applies to the others and is DuplicateLast unless set.
+	Duplicates       map[string]string
+	DefaultDuplicate string
+	// Routes override the whitelists and duplicate policies for requests
+	// matching a ServeMux pattern such as "GET /teachers". Nil whitelists are
+	// inherited, as are the duplicate policies the route does not set.
+	Routes map[string]HTTPOptions
+}
+
+// policy returns the duplicate policy for param.
+func (o *HTTPOptions) policy(param string) string {
+	if policy, ok := o.Duplicates[param]; ok {
+		return policy
+	}
+	return o.DefaultDuplicate
 }
 
 func Hpp(options HTTPOptions) func(http.Handler) http.Handler {
 	fmt.Println("HPP Middleware...")
+	if options.DefaultDuplicate == "" {
+		options.DefaultDuplicate = DuplicateLast
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = 1 << 20
+	}
+	routes := http.NewServeMux()
+	overrides := make(map[string]*HTTPOptions, len(options.Routes))
+	for pattern, route := range options.Routes {
+		routes.Handle(pattern, http.NotFoundHandler())
+		overrides[pattern] = inheritHpp(route, options)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			fmt.Println("---HPP Middleware start---")
-			if options.CheckBody && r.Method == http.MethodGet && isCorrectContentType(r, options.CheckBodyOnlyForContentType) {
-				filterBodyParams(r, options.Whitelist)
+			route := &options
+			if _, pattern := routes.Handler(r); pattern != "" {
+				route = overrides[pattern]
 			}
-			if options.CheckQuery && r.URL.Query() != nil {
-				filterQueryParams(r, options.Whitelist)
+
+			if route.CheckQuery && r.URL.RawQuery != "" {
+				query, err := filterValues(r.URL.Query(), route.QueryWhitelist, route)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				r.URL.RawQuery = query.Encode()
+			}
+			if route.CheckBody && hasBody(r) && isCorrectContentType(r, route.CheckBodyOnlyForContentType) {
+				if err := filterBody(r, route); err != nil {
+					BodyError(w, err, err.Error())
+					return
+				}
 			}
 			next.ServeHTTP(w, r)
-			fmt.Println("---HPP Middleware end---")
-
 		})
 	}
 }
 
+// inheritHpp fills in what a route override leaves unset from the defaults.
+func inheritHpp(route, defaults HTTPOptions) *HTTPOptions {
+	route.CheckQuery = defaults.CheckQuery
+	route.CheckBody = defaults.CheckBody
+	route.CheckBodyOnlyForContentType = defaults.CheckBodyOnlyForContentType
+	if route.MaxBodySize <= 0 {
+		route.MaxBodySize = defaults.MaxBodySize
+	}
+	if route.QueryWhitelist == nil {
+		route.QueryWhitelist = defaults.QueryWhitelist
+	}
+	if route.BodyWhitelist == nil {
+		route.BodyWhitelist = defaults.BodyWhitelist
+	}
+	if route.DefaultDuplicate == "" {
+		route.DefaultDuplicate = defaults.DefaultDuplicate
+	}
+	duplicates := make(map[string]string, len(defaults.Duplicates)+len(route.Duplicates))
+	for param, policy := range defaults.Duplicates {
+		duplicates[param] = policy
+	}
+	for param, policy := range route.Duplicates {
+		duplicates[param] = policy
+	}
+	route.Duplicates = duplicates
+	route.Routes = nil
+	return &route
+}
+
+func hasBody(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		return r.Body != nil && r.Body != http.NoBody
+	}
+	return false
+}
+
 func isCorrectContentType(r *http.Request, contentType string) bool {
 	if contentType == "" {
 		return true
 	}
-	return r.Header.Get("Content-Type") == contentType
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == contentType
 }
 
-func filterBodyParams(r *http.Request, whitelist []string) {
-	err := r.ParseForm()
-	if err != nil {
-		fmt.Print("Error parsing form: ", err)
-		return
+// filterValues drops the parameters missing from whitelist and applies the
+// duplicate policies to the rest.
+func filterValues(values url.Values, whitelist []string, options *HTTPOptions) (url.Values, error) {
+	for k, v := range values {
+		if len(whitelist) > 0 && !isWhitelisted(k, whitelist) {
+			delete(values, k)
+			continue
+		}
+		if len(v) < 2 {
+			continue
+		}
+		switch options.policy(k) {
+		case DuplicateFirst:
+			values[k] = v[:1]
+		case DuplicateReject:
+			return nil, fmt.Errorf("Duplicate parameter %q", k)
+		case DuplicateArray:
+		default:
+			values[k] = v[len(v)-1:]
+		}
 	}
+	return values, nil
+}
 
-	for k, v := range r.Form {
-		if len(v) > 1 {
-			r.Form.Set(k, v[0])
+// filterBody filters the body of r according to its content type. Form
+// bodies are parsed into r.Form and r.PostForm and written back, so handlers
+// reading the body directly see the filtered version too.
+func filterBody(r *http.Request, options *HTTPOptions) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		body, ok, err := readBody(r, options.MaxBodySize)
+		if !ok || err != nil {
+			return err
+		}
+		setBody(r, body)
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		form, err := filterValues(r.PostForm, options.BodyWhitelist, options)
+		if err != nil {
+			return err
+		}
+		setBody(r, []byte(form.Encode()))
+		r.Form = mergeValues(r.URL.Query(), form)
+	case mediaType == "multipart/form-data":
+		if err := r.ParseMultipartForm(maxFormMemory); err != nil {
+			return err
 		}
-		if !isWhitelisted(k, whitelist) {
-			delete(r.Form, k)
+		form, err := filterValues(r.MultipartForm.Value, options.BodyWhitelist, options)
+		if err != nil {
+			return err
 		}
+		r.MultipartForm.Value = form
+		r.PostForm = form
+		r.Form = mergeValues(r.URL.Query(), form)
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		body, ok, err := readBody(r, options.MaxBodySize)
+		if !ok || err != nil {
+			return err
+		}
+		filtered, err := filterJSON(body, options)
+		if err != nil {
+			return err
+		}
+		setBody(r, filtered)
 	}
+	return nil
 }
 
-func isWhitelisted(param string, whitelist []string) bool {
-	for _, item := range whitelist {
-		if item == param {
-			return true
-		}
+// readBody reads the body of r unless it is larger than limit, in which case
+// it reports false and leaves the body for the handler to read in full.
+func readBody(r *http.Request, limit int64) ([]byte, bool, error) {
+	if r.ContentLength > limit {
+		return nil, false, nil
 	}
-	return false
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return nil, false, nil
+	}
+	return body, true, nil
+}
+
+func setBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
 }
 
-func filterQueryParams(r *http.Request, whitelist []string) {
-	query := r.URL.Query()
+// mergeValues combines the query and form values the way ParseForm does,
+// body values first.
+func mergeValues(query, form url.Values) url.Values {
+	merged := make(url.Values, len(query)+len(form))
+	for k, v := range form {
+		merged[k] = append(merged[k], v...)
+	}
 	for k, v := range query {
+		merged[k] = append(merged[k], v...)
+	}
+	return merged
+}
+
+func isWhitelisted(param string, whitelist []string) bool {
+	return slices.Contains(whitelist, param)
+}
+
+// filterJSON rewrites a JSON body without duplicate object keys, at any
+// depth, and without the top level keys missing from the body whitelist.
+// A body that needs neither is returned unchanged; invalid JSON is left for
+// the handler to reject, but JSON nested deeper than maxJSONDepth is
+// rejected here.
+func filterJSON(body []byte, options *HTTPOptions) ([]byte, error) {
+	f := &jsonFilter{options: options}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	filtered, err := f.value(dec, 0)
+	var dup *duplicateKeyError
+	if errors.As(err, &dup) || errors.Is(err, errJSONTooDeep) {
+		return nil, err
+	}
+	if err != nil || !f.changed {
+		return body, nil
+	}
+	return filtered, nil
+}
 
-		if len(v) > 1 {
-			//query.Set(k, v[0])
-			query.Set(k, v[len(v)-1])
+type duplicateKeyError struct {
+	key string
+}
+
+func (e *duplicateKeyError) Error() string {
+	return fmt.Sprintf("Duplicate JSON key %q", e.key)
+}
+
+type jsonFilter struct {
+	options *HTTPOptions
+	changed bool
+}
+
+func (f *jsonFilter) value(dec *json.Decoder, depth int) (json.RawMessage, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return json.Marshal(tok)
+	}
+	if depth >= maxJSONDepth {
+		return nil, errJSONTooDeep
+	}
+
+	var buf bytes.Buffer
+	switch delim {
+	case '[':
+		buf.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			v, err := f.value(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(v)
+		}
+		buf.WriteByte(']')
+	case '{':
+		var keys []string
+		values := make(map[string][]json.RawMessage)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := tok.(string)
+			v, err := f.value(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if _, seen := values[key]; !seen {
+				keys = append(keys, key)
+			}
+			values[key] = append(values[key], v)
 		}
 
-		if !isWhitelisted(k, whitelist) {
-			query.Del(k)
+		buf.WriteByte('{')
+		written := 0
+		for _, key := range keys {
+			if depth == 0 && len(f.options.BodyWhitelist) > 0 && !isWhitelisted(key, f.options.BodyWhitelist) {
+				f.changed = true
+				continue
+			}
+			v, err := f.merge(key, values[key])
+			if err != nil {
+				return nil, err
+			}
+			if written > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ := json.Marshal(key)
+			buf.Write(name)
+			buf.WriteByte(':')
+			buf.Write(v)
+			written++
 		}
+		buf.WriteByte('}')
+	}
+	// Consume the closing delimiter.
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
+// merge applies the duplicate policy to the values of a repeated key.
+func (f *jsonFilter) merge(key string, values []json.RawMessage) (json.RawMessage, error) {
+	if len(values) == 1 {
+		return values[0], nil
+	}
+	f.changed = true
+	switch f.options.policy(key) {
+	case DuplicateFirst:
+		return values[0], nil
+	case DuplicateReject:
+		return nil, &duplicateKeyError{key: key}
+	case DuplicateArray:
+		return json.Marshal(values)
+	default:
+		return values[len(values)-1], nil
 	}
-	r.URL.RawQuery = query.Encode()
 }
@@ -0,0 +1,160 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sendBody sends body with the given content type through h.
+func sendBody(h http.Handler, target, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, body)
+	r.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestHppJSONDepth(t *testing.T) {
+	h := Hpp(HTTPOptions{CheckBody: true})(echo)
+
+	deep := strings.Repeat("[", maxJSONDepth) + strings.Repeat("]", maxJSONDepth)
+	if rec := sendBody(h, "/", "application/json", strings.NewReader(deep)); rec.Code != http.StatusOK || rec.Body.String() != deep {
+		t.Errorf("%d levels: got %d %q", maxJSONDepth, rec.Code, rec.Body)
+	}
+	for _, body := range []string{
+		strings.Repeat("[", maxJSONDepth+1) + strings.Repeat("]", maxJSONDepth+1),
+		strings.Repeat(`{"a":`, maxJSONDepth+1) + "1" + strings.Repeat("}", maxJSONDepth+1),
+		// Unterminated, and far deeper than the stack should go.
+		strings.Repeat("[", 1<<20),
+	} {
+		if rec := sendBody(h, "/", "application/json", strings.NewReader(body)); rec.Code != http.StatusBadRequest {
+			t.Errorf("%d byte body nested too deeply: got %d, want 400", len(body), rec.Code)
+		}
+	}
+}
+
+func TestHppMaxBodySize(t *testing.T) {
+	h := Hpp(HTTPOptions{
+		CheckBody:   true,
+		MaxBodySize: 32,
+		Routes:      map[string]HTTPOptions{"POST /large": {MaxBodySize: 1 << 10}},
+	})(echo)
+	small := `{"a":1,"a":2}`
+	large := `{"a":1,"a":2,"padding":"` + strings.Repeat("x", 64) + `"}`
+
+	for _, c := range []struct {
+		name   string
+		target string
+		body   io.Reader
+		want   string
+	}{
+		{"small body", "/", strings.NewReader(small), `{"a":2}`},
+		{"large body", "/", strings.NewReader(large), large},
+		// Without a Content-Length the body is read up to the limit.
+		{"large body of unknown length", "/", io.MultiReader(strings.NewReader(large)), large},
+		{"large body on a route allowed more", "/large", strings.NewReader(large), `{"a":2,"padding":"` + strings.Repeat("x", 64) + `"}`},
+	} {
+		rec := sendBody(h, c.target, "application/json", c.body)
+		if rec.Code != http.StatusOK || rec.Body.String() != c.want {
+			t.Errorf("%s: got %d %q, want %q", c.name, rec.Code, rec.Body, c.want)
+		}
+	}
+
+	form := "a=1&a=2&padding=" + strings.Repeat("x", 64)
+	if rec := sendBody(h, "/", "application/x-www-form-urlencoded", strings.NewReader(form)); rec.Body.String() != form {
+		t.Errorf("large form: got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestHppQuery(t *testing.T) {
+	h := Hpp(HTTPOptions{
+		CheckQuery: true,
+		Duplicates: map[string]string{"first": DuplicateFirst, "reject": DuplicateReject, "array": DuplicateArray},
+		Routes: map[string]HTTPOptions{
+			"GET /teachers": {QueryWhitelist: []string{"name", "array"}, Duplicates: map[string]string{"array": DuplicateLast}},
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.RawQuery)
+	}))
+
+	for _, c := range []struct {
+		target string
+		status int
+		query  string
+	}{
+		{"/?name=a&name=b", http.StatusOK, "name=b"},
+		{"/?first=a&first=b", http.StatusOK, "first=a"},
+		{"/?array=a&array=b", http.StatusOK, "array=a&array=b"},
+		{"/?reject=a", http.StatusOK, "reject=a"},
+		{"/?reject=a&reject=b", http.StatusBadRequest, ""},
+		// The route's whitelist drops the rest, and its policies win.
+		{"/teachers?name=a&sort=b&array=a&array=b", http.StatusOK, "array=b&name=a"},
+		{"/teachers?first=a&first=b", http.StatusOK, ""},
+	} {
+		rec := get(h, c.target)
+		if rec.Code != c.status || c.status == http.StatusOK && rec.Body.String() != c.query {
+			t.Errorf("%s: got %d %q, want %d %q", c.target, rec.Code, rec.Body, c.status, c.query)
+		}
+	}
+}
+
+func TestHppForm(t *testing.T) {
+	var form, body string
+	h := Hpp(HTTPOptions{
+		CheckBody:     true,
+		BodyWhitelist: []string{"name", "tag", "id"},
+		Duplicates:    map[string]string{"tag": DuplicateArray, "id": DuplicateReject},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, form = string(b), r.Form.Encode()
+	}))
+
+	rec := sendBody(h, "/?q=1", "application/x-www-form-urlencoded", strings.NewReader("name=a&name=b&tag=x&tag=y&other=z"))
+	if rec.Code != http.StatusOK || body != "name=b&tag=x&tag=y" || form != "name=b&q=1&tag=x&tag=y" {
+		t.Errorf("got %d, body %q, form %q", rec.Code, body, form)
+	}
+	if rec := sendBody(h, "/", "application/x-www-form-urlencoded", strings.NewReader("id=1&id=2")); rec.Code != http.StatusBadRequest {
+		t.Errorf("rejected duplicate: got %d", rec.Code)
+	}
+
+	multipart := "--b\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\na\r\n" +
+		"--b\r\nContent-Disposition: form-data; name=\"name\"\r\n\r\nb\r\n" +
+		"--b\r\nContent-Disposition: form-data; name=\"other\"\r\n\r\nz\r\n--b--\r\n"
+	if rec := sendBody(h, "/", "multipart/form-data; boundary=b", strings.NewReader(multipart)); rec.Code != http.StatusOK || form != "name=b" {
+		t.Errorf("multipart: got %d, form %q", rec.Code, form)
+	}
+}
+
+func TestHppJSON(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		options HTTPOptions
+		body    string
+		status  int
+		want    string
+	}{
+		{"last by default", HTTPOptions{}, `{"a":1,"b":2,"a":3}`, http.StatusOK, `{"a":3,"b":2}`},
+		{"first", HTTPOptions{DefaultDuplicate: DuplicateFirst}, `{"a":1,"a":3}`, http.StatusOK, `{"a":1}`},
+		{"array", HTTPOptions{Duplicates: map[string]string{"a": DuplicateArray}}, `{"a":1,"a":{"b":2}}`, http.StatusOK, `{"a":[1,{"b":2}]}`},
+		{"reject", HTTPOptions{Duplicates: map[string]string{"a": DuplicateReject}}, `{"a":1,"a":3}`, http.StatusBadRequest, ""},
+		{"nested duplicates", HTTPOptions{}, `{"x":[{"a":1,"a":2}]}`, http.StatusOK, `{"x":[{"a":2}]}`},
+		{"whitelist applies to the top level", HTTPOptions{BodyWhitelist: []string{"x"}}, `{"x":{"y":1},"z":2}`, http.StatusOK, `{"x":{"y":1}}`},
+		{"unchanged body kept as sent", HTTPOptions{}, "{ \"a\" : 1.50 }", http.StatusOK, "{ \"a\" : 1.50 }"},
+		{"invalid JSON left to the handler", HTTPOptions{}, `{"a":1,`, http.StatusOK, `{"a":1,`},
+	} {
+		c.options.CheckBody = true
+		rec := sendBody(Hpp(c.options)(echo), "/", "application/merge-patch+json", strings.NewReader(c.body))
+		if rec.Code != c.status || c.status == http.StatusOK && rec.Body.String() != c.want {
+			t.Errorf("%s: got %d %q, want %d %q", c.name, rec.Code, rec.Body, c.status, c.want)
+		}
+	}
+
+	// Bodies of other types are left alone.
+	h := Hpp(HTTPOptions{CheckBody: true, CheckBodyOnlyForContentType: "application/x-www-form-urlencoded"})(echo)
+	if rec := sendBody(h, "/", "application/json", strings.NewReader(`{"a":1,"a":2}`)); rec.Body.String() != `{"a":1,"a":2}` {
+		t.Errorf("unchecked content type: got %q", rec.Body)
+	}
+}
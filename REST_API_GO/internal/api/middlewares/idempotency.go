@@ -0,0 +1,244 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// IdempotencyOptions configures Idempotency.
+type IdempotencyOptions struct {
+	// Store keeps the responses; an in-memory store by default.
+	Store IdempotencyStore
+	// TTL is how long a response is replayed for retries; 24 hours by
+	// default.
+	TTL time.Duration
+	// LockTimeout is how long a key stays claimed by a request still being
+	// handled, in case its replica dies before answering; 1 minute by
+	// default. It should outlast the handler deadlines.
+	LockTimeout time.Duration
+	// Methods honor the header; POST and PATCH by default.
+	Methods []string
+	// MaxResponseSize is the largest response stored; 1 MiB by default.
+	// Larger responses are sent but a retry runs the request again.
+	MaxResponseSize int64
+}
+
+// IdempotentResponse is a stored request fingerprint and, once the request
+// has been handled, its response.
+type IdempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"`
+	Done        bool        `json:"done"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// IdempotencyStore keeps idempotency keys and their responses.
+type IdempotencyStore interface {
+	// Reserve claims key for a request with fingerprint for lockTimeout. It
+	// returns nil if the key was free, or what is stored under it.
+	Reserve(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotentResponse, error)
+	// Save stores the response of the request that reserved key for ttl.
+	Save(ctx context.Context, key string, response *IdempotentResponse, ttl time.Duration) error
+	// Release frees key without storing a response, so a retry runs again.
+	Release(ctx context.Context, key string) error
+}
+
+const maxIdempotencyKeyLength = 255
+
+// Idempotency makes retries of requests carrying an Idempotency-Key header
+// safe. The first response for a key is stored and replayed, marked with
+// "Idempotent-Replayed: true", to retries within the TTL. A retry arriving
+// while the first request is still being handled gets 409, and reusing a key
+// for a different method, URL or body gets 422.
+//
+// Keys are scoped to the API key, or the client IP for anonymous requests,
+// so clients cannot see each other's responses. Server errors are not
+// stored, leaving the request free to be retried. If the store fails the
+// request is served without the guarantee rather than rejected.
+//
+// Access checks must run before it, or their 401 and 403 responses would be
+// stored and replayed to a retry that is then allowed.
+func Idempotency(options IdempotencyOptions) func(http.Handler) http.Handler {
+	fmt.Println("Idempotency Middleware...")
+	if options.Store == nil {
+		options.Store = NewMemoryIdempotencyStore()
+	}
+	if options.TTL <= 0 {
+		options.TTL = 24 * time.Hour
+	}
+	if options.LockTimeout <= 0 {
+		options.LockTimeout = time.Minute
+	}
+	if options.Methods == nil {
+		options.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if options.MaxResponseSize <= 0 {
+		options.MaxResponseSize = 1 << 20
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idempotencyKey := r.Header.Get("Idempotency-Key")
+			if idempotencyKey == "" || !slices.Contains(options.Methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(idempotencyKey) > maxIdempotencyKeyLength {
+				http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+				return
+			}
+
+			var body []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				var err error
+				body, err = io.ReadAll(r.Body)
+				if err != nil {
+					BodyError(w, err, "Error reading request body")
+					return
+				}
+				setBody(r, body)
+			}
+			fingerprint := requestFingerprint(r, body)
+			key := idempotencyScope(r) + ":" + idempotencyKey
+
+			stored, err := options.Store.Reserve(r.Context(), key, fingerprint, options.LockTimeout)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error reserving idempotency key", "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if stored != nil {
+				switch {
+				case stored.Fingerprint != fingerprint:
+					http.Error(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+				case !stored.Done:
+					w.Header().Set("Retry-After", "1")
+					http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+				default:
+					h := w.Header()
+					for name, values := range stored.Header {
+						h[name] = slices.Clone(values)
+					}
+					h.Set("Idempotent-Replayed", "true")
+					w.WriteHeader(stored.Status)
+					_, _ = w.Write(stored.Body)
+				}
+				return
+			}
+
+			// The request must not leave its key claimed, whatever happens
+			// to it; the store gets its own context since r's may be done.
+			saved := false
+			defer func() {
+				if !saved {
+					if err := options.Store.Release(context.WithoutCancel(r.Context()), key); err != nil {
+						slog.ErrorContext(r.Context(), "Error releasing idempotency key", "err", err)
+					}
+				}
+			}()
+
+			before := w.Header().Clone()
+			cw := &cacheWriter{ResponseWriter: w, status: http.StatusOK, limit: options.MaxResponseSize}
+			next.ServeHTTP(cw, r)
+			if cw.status >= 500 || cw.tooLarge {
+				return
+			}
+			response := &IdempotentResponse{
+				Fingerprint: fingerprint,
+				Done:        true,
+				Status:      cw.status,
+				Header:      changedHeaders(before, w.Header()),
+				Body:        cw.body.Bytes(),
+			}
+			if err := options.Store.Save(context.WithoutCancel(r.Context()), key, response, options.TTL); err != nil {
+				slog.ErrorContext(r.Context(), "Error saving idempotent response", "err", err)
+				return
+			}
+			saved = true
+		})
+	}
+}
+
+// idempotencyScope identifies who sent r.
+func idempotencyScope(r *http.Request) string {
+	if apiKey := CurrentAPIKey(r); apiKey != nil {
+		return "key:" + apiKey.Name
+	}
+	return "ip:" + ClientIP(r)
+}
+
+// requestFingerprint hashes what makes a request the same request.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MemoryIdempotencyStore keeps idempotency keys in the process. Keys are
+// not shared between replicas; use RedisIdempotencyStore for that.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	lastSweep time.Time
+}
+
+type memoryIdempotencyEntry struct {
+	response *IdempotentResponse
+	expires  time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]memoryIdempotencyEntry)}
+}
+
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		return e.response, nil
+	}
+	s.entries[key] = memoryIdempotencyEntry{
+		response: &IdempotentResponse{Fingerprint: fingerprint},
+		expires:  now.Add(lockTimeout),
+	}
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Save(ctx context.Context, key string, response *IdempotentResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryIdempotencyEntry{response: response, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep drops expired keys, at most once a minute.
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisIdempotencyStore keeps idempotency keys in Redis so that a retry
+// reaching another replica is still recognized.
+type RedisIdempotencyStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisIdempotencyStore(client redis.UniversalClient) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{client: client}
+}
+
+func redisIdempotencyKey(key string) string {
+	return "idempotency:" + key
+}
+
+func (s *RedisIdempotencyStore) Reserve(ctx context.Context, key, fingerprint string, lockTimeout time.Duration) (*IdempotentResponse, error) {
+	reservation, err := json.Marshal(&IdempotentResponse{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+	// The key may expire between a failed SETNX and the GET; try again then.
+	for range 3 {
+		ok, err := s.client.SetNX(ctx, redisIdempotencyKey(key), reservation, lockTimeout).Result()
+		if err != nil || ok {
+			return nil, err
+		}
+		data, err := s.client.Get(ctx, redisIdempotencyKey(key)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var stored IdempotentResponse
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, err
+		}
+		return &stored, nil
+	}
+	return nil, errors.New("idempotency key keeps expiring")
+}
+
+func (s *RedisIdempotencyStore) Save(ctx context.Context, key string, response *IdempotentResponse, ttl time.Duration) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, redisIdempotencyKey(key), data, ttl).Err()
+}
+
+func (s *RedisIdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, redisIdempotencyKey(key)).Err()
+}
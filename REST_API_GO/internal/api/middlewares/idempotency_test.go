@@ -0,0 +1,228 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// post sends a POST with body and Idempotency-Key key through h from
+// remoteAddr.
+func post(h http.Handler, key, body, remoteAddr string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/teachers", strings.NewReader(body))
+	r.RemoteAddr = remoteAddr
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+const testClientAddr = "192.0.2.1:1234"
+
+func TestIdempotencyReplay(t *testing.T) {
+	calls := 0
+	h := Idempotency(IdempotencyOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Location", fmt.Sprintf("/teachers/%d", calls))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id":%d}`, calls)
+	}))
+
+	first := post(h, "k1", `{"name":"Ada"}`, testClientAddr)
+	retry := post(h, "k1", `{"name":"Ada"}`, testClientAddr)
+	if calls != 1 {
+		t.Fatalf("handler called %d times for a retry, want 1", calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get("Location") != "/teachers/1" {
+		t.Errorf("retry got %d %q %q, want the first response", retry.Code, retry.Header().Get("Location"), retry.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry is not marked as replayed")
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("first response is marked as replayed")
+	}
+
+	// Other keys, other clients and requests without a key run again.
+	post(h, "k2", `{"name":"Ada"}`, testClientAddr)
+	post(h, "k1", `{"name":"Ada"}`, "198.51.100.7:1234")
+	post(h, "", `{"name":"Ada"}`, testClientAddr)
+	post(h, "", `{"name":"Ada"}`, testClientAddr)
+	if calls != 5 {
+		t.Errorf("handler called %d times, want 5", calls)
+	}
+}
+
+func TestIdempotencyKeyReuse(t *testing.T) {
+	h := Idempotency(IdempotencyOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post(h, "k1", `{"name":"Ada"}`, testClientAddr)
+	if rec := post(h, "k1", `{"name":"Grace"}`, testClientAddr); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another body: got %d, want 422", rec.Code)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := Idempotency(IdempotencyOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(h, "k1", `{}`, testClientAddr) }()
+	<-started
+	rec := post(h, "k1", `{}`, testClientAddr)
+	close(release)
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("retry during the first request: got %d Retry-After %q, want 409 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Errorf("first request got %d", first.Code)
+	}
+}
+
+func TestIdempotencyReleasesFailedRequests(t *testing.T) {
+	status := http.StatusInternalServerError
+	calls := 0
+	h := Idempotency(IdempotencyOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 2 {
+			panic("boom")
+		}
+		w.WriteHeader(status)
+	}))
+
+	if rec := post(h, "k1", `{}`, testClientAddr); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("first request got %d", rec.Code)
+	}
+	// The server error was not stored and the key is free again, as it is
+	// after a panic.
+	func() {
+		defer func() { _ = recover() }()
+		post(h, "k1", `{}`, testClientAddr)
+	}()
+	status = http.StatusCreated
+	if rec := post(h, "k1", `{}`, testClientAddr); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("retry got %d replayed %q, want a fresh 201", rec.Code, rec.Header().Get("Idempotent-Replayed"))
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+}
+
+// beforeCommand runs a function before each Redis command of a given name,
+// to change the keys between the commands of a Reserve.
+type beforeCommand map[string]func()
+
+func (h beforeCommand) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h beforeCommand) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if f := h[cmd.Name()]; f != nil {
+			f()
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h beforeCommand) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func newRedisIdempotencyStore(t *testing.T, hook beforeCommand) (*miniredis.Miniredis, *RedisIdempotencyStore) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	if hook != nil {
+		client.AddHook(hook)
+	}
+	return m, NewRedisIdempotencyStore(client)
+}
+
+func TestRedisIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	_, store := newRedisIdempotencyStore(t, nil)
+
+	if stored, err := store.Reserve(ctx, "k1", "f1", time.Minute); err != nil || stored != nil {
+		t.Fatalf("Reserve of a free key = %+v, %v", stored, err)
+	}
+	stored, err := store.Reserve(ctx, "k1", "f1", time.Minute)
+	if err != nil || stored == nil || stored.Done || stored.Fingerprint != "f1" {
+		t.Fatalf("Reserve of a claimed key = %+v, %v", stored, err)
+	}
+
+	response := &IdempotentResponse{Fingerprint: "f1", Done: true, Status: http.StatusCreated, Body: []byte(`{}`)}
+	if err := store.Save(ctx, "k1", response, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	stored, err = store.Reserve(ctx, "k1", "f1", time.Minute)
+	if err != nil || stored == nil || !stored.Done || stored.Status != http.StatusCreated || string(stored.Body) != `{}` {
+		t.Fatalf("Reserve of a saved key = %+v, %v", stored, err)
+	}
+
+	if err := store.Release(ctx, "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := store.Reserve(ctx, "k1", "f2", time.Minute); err != nil || stored != nil {
+		t.Errorf("Reserve of a released key = %+v, %v", stored, err)
+	}
+}
+
+// TestRedisIdempotencyStoreKeyExpires checks Reserve claims a key that
+// expires between its SETNX and its GET.
+func TestRedisIdempotencyStoreKeyExpires(t *testing.T) {
+	ctx := context.Background()
+	var m *miniredis.Miniredis
+	gets := 0
+	m, store := newRedisIdempotencyStore(t, beforeCommand{"get": func() {
+		if gets++; gets == 1 {
+			m.Del(redisIdempotencyKey("k1"))
+		}
+	}})
+
+	if _, err := store.Reserve(ctx, "k1", "f1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Reserve(ctx, "k1", "f2", time.Minute)
+	if err != nil || stored != nil {
+		t.Fatalf("Reserve after the key expired = %+v, %v, want the key claimed", stored, err)
+	}
+	stored, err = store.Reserve(ctx, "k1", "f3", time.Minute)
+	if err != nil || stored == nil || stored.Fingerprint != "f2" {
+		t.Errorf("key claimed on the retry holds %+v, %v, want fingerprint f2", stored, err)
+	}
+}
+
+// TestRedisIdempotencyStoreKeyKeepsExpiring checks Reserve gives up when the
+// key is claimed before every SETNX and expires before every GET.
+func TestRedisIdempotencyStoreKeyKeepsExpiring(t *testing.T) {
+	var m *miniredis.Miniredis
+	key := redisIdempotencyKey("k1")
+	m, store := newRedisIdempotencyStore(t, beforeCommand{
+		"set": func() { _ = m.Set(key, `{"fingerprint":"other"}`) },
+		"get": func() { m.Del(key) },
+	})
+
+	if stored, err := store.Reserve(context.Background(), "k1", "f1", time.Minute); err == nil {
+		t.Errorf("Reserve = %+v, want an error", stored)
+	}
+}
@@ -0,0 +1,234 @@
+package middlewares
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rate limiting algorithms accepted in RateLimitOptions.Algorithm.
+const (
+	AlgorithmTokenBucket          = "token_bucket"
+	AlgorithmLeakyBucket          = "leaky_bucket"
+	AlgorithmFixedWindow          = "fixed_window"
+	AlgorithmSlidingWindowLog     = "sliding_window_log"
+	AlgorithmSlidingWindowCounter = "sliding_window_counter"
+)
+
+// Decision is the outcome of a single Limiter.Allow call.
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests the key may make in a burst.
+	Limit int
+	// Remaining is how many more requests the key may make right now.
+	Remaining int
+	// Reset is how long until the key's full limit is available again.
+	Reset time.Duration
+	// RetryAfter is how long a rejected key has to wait for its next request.
+	RetryAfter time.Duration
+	// Unavailable is set when the request was rejected because the shared
+	// store could not be reached, rather than because of its key's budget.
+	Unavailable bool
+}
+
+// Limiter decides whether the request identified by key may proceed.
+type Limiter interface {
+	Allow(key string) Decision
+}
+
+// RateLimitOptions configures NewLimiter.
+type RateLimitOptions struct {
+	// Algorithm is one of the Algorithm constants; the default is the token bucket.
+	Algorithm string
+	// Limit requests are allowed per Window.
+	Limit  int
+	Window time.Duration
+	// Burst is the bucket capacity of the token and leaky bucket algorithms.
+	// It defaults to Limit and is ignored by the window algorithms.
+	Burst int
+
+	// Key picks the bucket a request counts against; KeyByClientIP by
+	// default. Only used by NewRateLimitter.
+	Key KeyFunc
+	// Routes override the options for requests matching a ServeMux pattern
+	// such as "POST /execs/login". Zero fields are inherited. Only used by
+	// NewRateLimitter.
+	Routes map[string]RateLimitOptions
+
+	// Store shares the limits between replicas. When nil every replica
+	// enforces its own limits. Only used by NewRateLimitter.
+	Store RateLimitStore
+	// FailurePolicy is FailOpen (the default) or FailClosed.
+	FailurePolicy string
+}
+
+// NewLimiter returns a Limiter that tracks every key separately using the
+// configured algorithm.
+func NewLimiter(options RateLimitOptions) (Limiter, error) {
+	options, err := checkOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var newBucket func(now time.Time) bucket
+	switch options.Algorithm {
+	case AlgorithmTokenBucket:
+		newBucket = func(now time.Time) bucket { return newTokenBucket(options, now) }
+	case AlgorithmLeakyBucket:
+		newBucket = func(now time.Time) bucket { return newLeakyBucket(options, now) }
+	case AlgorithmFixedWindow:
+		newBucket = func(now time.Time) bucket { return &fixedWindow{options: options} }
+	case AlgorithmSlidingWindowLog:
+		newBucket = func(now time.Time) bucket { return &slidingWindowLog{options: options} }
+	case AlgorithmSlidingWindowCounter:
+		newBucket = func(now time.Time) bucket { return &slidingWindowCounter{options: options} }
+	}
+
+	// A bucket is back at its initial state once the sliding window
+	// counter's two windows have passed and a bucket has refilled or drained.
+	idle := 2 * options.Window
+	if options.Burst > options.Limit {
+		idle = max(idle, options.Window*time.Duration(options.Burst)/time.Duration(options.Limit))
+	}
+	return newKeyedLimiter(newBucket, idle), nil
+}
+
+// checkOptions validates options and fills in the defaults.
+func checkOptions(options RateLimitOptions) (RateLimitOptions, error) {
+	if options.Limit <= 0 || options.Window <= 0 {
+		return options, fmt.Errorf("rate limit needs a positive limit and window, got %d per %s", options.Limit, options.Window)
+	}
+	if options.Burst <= 0 {
+		options.Burst = options.Limit
+	}
+	switch options.Algorithm {
+	case "":
+		options.Algorithm = AlgorithmTokenBucket
+	case AlgorithmTokenBucket, AlgorithmLeakyBucket, AlgorithmFixedWindow, AlgorithmSlidingWindowLog, AlgorithmSlidingWindowCounter:
+	default:
+		return options, fmt.Errorf("unknown rate limit algorithm %q", options.Algorithm)
+	}
+	switch options.FailurePolicy {
+	case "":
+		options.FailurePolicy = FailOpen
+	case FailOpen, FailClosed:
+	default:
+		return options, fmt.Errorf("unknown rate limit failure policy %q", options.FailurePolicy)
+	}
+	return options, nil
+}
+
+// bucket is the per-key state of an algorithm. It is only used while the
+// lock of the entry holding it is held.
+type bucket interface {
+	allow(now time.Time) Decision
+}
+
+// limiterShards spreads keys over independently locked maps so that
+// requests for different clients rarely wait for each other.
+const limiterShards = 64
+
+// keyedLimiter holds one bucket per key. A request only locks its key's
+// shard long enough to find the entry, and then that entry while the
+// algorithm updates it; nothing is locked while the request is served.
+type keyedLimiter struct {
+	seed      maphash.Seed
+	shards    [limiterShards]limiterShard
+	newBucket func(now time.Time) bucket
+	// ttl is how long a key must be unused before its bucket is back at its
+	// initial state and can be evicted.
+	ttl time.Duration
+	// now is the clock, replaced in tests.
+	now func() time.Time
+}
+
+type limiterShard struct {
+	mu        sync.RWMutex
+	entries   map[string]*limiterEntry
+	nextSweep atomic.Int64 // unix nanoseconds
+}
+
+type limiterEntry struct {
+	mu       sync.Mutex
+	bucket   bucket
+	lastSeen atomic.Int64 // unix nanoseconds
+}
+
+func newKeyedLimiter(newBucket func(now time.Time) bucket, ttl time.Duration) *keyedLimiter {
+	l := &keyedLimiter{
+		seed:      maphash.MakeSeed(),
+		newBucket: newBucket,
+		ttl:       ttl,
+		now:       time.Now,
+	}
+	now := time.Now().UnixNano()
+	for i := range l.shards {
+		l.shards[i].entries = make(map[string]*limiterEntry)
+		l.shards[i].nextSweep.Store(now + int64(ttl))
+	}
+	return l
+}
+
+func (l *keyedLimiter) Allow(key string) Decision {
+	now := l.now()
+	shard := &l.shards[maphash.String(l.seed, key)%limiterShards]
+	shard.sweep(now, l.ttl)
+
+	e := shard.entry(key, now, l.newBucket)
+	e.lastSeen.Store(now.UnixNano())
+	e.mu.Lock()
+	d := e.bucket.allow(now)
+	e.mu.Unlock()
+	return d
+}
+
+// entry returns the entry for key, creating it on first use.
+func (s *limiterShard) entry(key string, now time.Time, newBucket func(time.Time) bucket) *limiterEntry {
+	s.mu.RLock()
+	e, exists := s.entries[key]
+	s.mu.RUnlock()
+	if exists {
+		return e
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, exists = s.entries[key]; !exists {
+		e = &limiterEntry{bucket: newBucket(now)}
+		s.entries[key] = e
+	}
+	return e
+}
+
+// sweep evicts the shard's idle entries at most once per ttl. It runs on the
+// request path instead of in a background goroutine, so a limiter that is
+// no longer used costs nothing. A request racing with the eviction of its
+// entry may update a bucket that is being dropped; since the entry was idle
+// for a full ttl its state was the initial one anyway.
+func (s *limiterShard) sweep(now time.Time, ttl time.Duration) {
+	next := s.nextSweep.Load()
+	if now.UnixNano() < next || !s.nextSweep.CompareAndSwap(next, now.UnixNano()+int64(ttl)) {
+		return
+	}
+
+	cutoff := now.Add(-ttl).UnixNano()
+	s.mu.Lock()
+	for key, e := range s.entries {
+		if e.lastSeen.Load() < cutoff {
+			delete(s.entries, key)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// durationFor converts an amount of requests at the given rate, in requests
+// per second, into time.
+func durationFor(amount, rate float64) time.Duration {
+	if amount <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(amount / rate * float64(time.Second)))
+}
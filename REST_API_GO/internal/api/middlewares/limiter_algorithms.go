@@ -0,0 +1,173 @@
+package middlewares
+
+import (
+	"math"
+	"time"
+)
+
+// tokenBucket refills Limit tokens per Window up to Burst tokens, and every
+// request takes one. This is the algorithm from
+// advanced/rate_lmiting_token_bucket_algorithm.go, with fractional refills so
+// that short windows are not rounded down to whole seconds.
+type tokenBucket struct {
+	capacity   float64
+	tokens     float64
+	refillRate float64 // tokens per second
+	last       time.Time
+}
+
+func newTokenBucket(options RateLimitOptions, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		capacity:   float64(options.Burst),
+		tokens:     float64(options.Burst),
+		refillRate: float64(options.Limit) / options.Window.Seconds(),
+		last:       now,
+	}
+}
+
+func (tb *tokenBucket) allow(now time.Time) Decision {
+	tb.tokens = math.Min(tb.capacity, tb.tokens+now.Sub(tb.last).Seconds()*tb.refillRate)
+	tb.last = now
+
+	d := Decision{Limit: int(tb.capacity)}
+	if tb.tokens >= 1 {
+		tb.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = durationFor(1-tb.tokens, tb.refillRate)
+	}
+	d.Remaining = int(tb.tokens)
+	d.Reset = durationFor(tb.capacity-tb.tokens, tb.refillRate)
+	return d
+}
+
+// leakyBucket fills by one per request and leaks Limit requests per Window;
+// a request that would overflow its Burst capacity is rejected. This is the
+// algorithm from advanced/rate_limiting_leaky_bucket_algorithm.go.
+type leakyBucket struct {
+	capacity float64
+	level    float64
+	leakRate float64 // requests per second
+	last     time.Time
+}
+
+func newLeakyBucket(options RateLimitOptions, now time.Time) *leakyBucket {
+	return &leakyBucket{
+		capacity: float64(options.Burst),
+		leakRate: float64(options.Limit) / options.Window.Seconds(),
+		last:     now,
+	}
+}
+
+func (lb *leakyBucket) allow(now time.Time) Decision {
+	lb.level = math.Max(0, lb.level-now.Sub(lb.last).Seconds()*lb.leakRate)
+	lb.last = now
+
+	d := Decision{Limit: int(lb.capacity)}
+	if lb.level+1 <= lb.capacity {
+		lb.level++
+		d.Allowed = true
+	} else {
+		d.RetryAfter = durationFor(lb.level+1-lb.capacity, lb.leakRate)
+	}
+	d.Remaining = int(lb.capacity - lb.level)
+	d.Reset = durationFor(lb.level, lb.leakRate)
+	return d
+}
+
+// fixedWindow counts requests in consecutive windows of Window length, as in
+// advanced/rate_limiting_fixed_window_counter.go. Windows are aligned to the
+// clock so every key resets at the same moment.
+type fixedWindow struct {
+	options RateLimitOptions
+	start   time.Time
+	count   int
+}
+
+func (fw *fixedWindow) allow(now time.Time) Decision {
+	if start := now.Truncate(fw.options.Window); !start.Equal(fw.start) {
+		fw.start = start
+		fw.count = 0
+	}
+
+	d := Decision{Limit: fw.options.Limit, Reset: fw.start.Add(fw.options.Window).Sub(now)}
+	if fw.count < fw.options.Limit {
+		fw.count++
+		d.Allowed = true
+	} else {
+		d.RetryAfter = d.Reset
+	}
+	d.Remaining = fw.options.Limit - fw.count
+	return d
+}
+
+// slidingWindowLog remembers the time of every allowed request and allows a
+// new one while fewer than Limit happened during the last Window. It is exact
+// but keeps up to Limit timestamps per key.
+type slidingWindowLog struct {
+	options RateLimitOptions
+	log     []time.Time
+}
+
+func (sw *slidingWindowLog) allow(now time.Time) Decision {
+	cutoff := now.Add(-sw.options.Window)
+	expired := 0
+	for expired < len(sw.log) && !sw.log[expired].After(cutoff) {
+		expired++
+	}
+	sw.log = sw.log[expired:]
+
+	d := Decision{Limit: sw.options.Limit}
+	if len(sw.log) < sw.options.Limit {
+		sw.log = append(sw.log, now)
+		d.Allowed = true
+	} else {
+		d.RetryAfter = sw.log[0].Add(sw.options.Window).Sub(now)
+	}
+	d.Remaining = sw.options.Limit - len(sw.log)
+	if len(sw.log) > 0 {
+		d.Reset = sw.log[len(sw.log)-1].Add(sw.options.Window).Sub(now)
+	}
+	return d
+}
+
+// slidingWindowCounter approximates the sliding log with two fixed windows:
+// the previous window's count is weighted by how much of it still overlaps
+// the sliding window. It needs constant memory per key.
+type slidingWindowCounter struct {
+	options  RateLimitOptions
+	start    time.Time
+	previous int
+	current  int
+}
+
+func (sc *slidingWindowCounter) allow(now time.Time) Decision {
+	window := sc.options.Window
+	start := now.Truncate(window)
+	switch elapsedWindows := int(start.Sub(sc.start) / window); {
+	case elapsedWindows == 1:
+		sc.previous, sc.current = sc.current, 0
+	case elapsedWindows > 1:
+		sc.previous, sc.current = 0, 0
+	}
+	sc.start = start
+
+	limit := float64(sc.options.Limit)
+	overlap := 1 - float64(now.Sub(start))/float64(window)
+	estimate := float64(sc.previous)*overlap + float64(sc.current)
+
+	d := Decision{Limit: sc.options.Limit, Reset: start.Add(2 * window).Sub(now)}
+	if estimate+1 <= limit {
+		sc.current++
+		estimate++
+		d.Allowed = true
+	} else if sc.previous > 0 && float64(sc.current)+1 <= limit {
+		// Wait until enough of the previous window has slid out.
+		needed := (estimate + 1 - limit) / float64(sc.previous)
+		d.RetryAfter = time.Duration(math.Ceil(needed * float64(window)))
+	} else {
+		d.RetryAfter = start.Add(window).Sub(now)
+	}
+	d.Remaining = max(0, int(limit-estimate))
+	return d
+}
@@ -0,0 +1,301 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a limiter clock that only moves when told to.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+// clockStart is aligned to the windows used in the tests, as the window
+// algorithms align theirs to the clock.
+var clockStart = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// limiterStep advances the clock, makes a request and expects a decision.
+type limiterStep struct {
+	advance time.Duration
+	want    Decision
+}
+
+func TestLimiterAlgorithms(t *testing.T) {
+	allowed := func(limit, remaining int, reset time.Duration) Decision {
+		return Decision{Allowed: true, Limit: limit, Remaining: remaining, Reset: reset}
+	}
+	rejected := func(limit int, reset, retryAfter time.Duration) Decision {
+		return Decision{Limit: limit, Reset: reset, RetryAfter: retryAfter}
+	}
+	ms := time.Millisecond
+
+	for _, c := range []struct {
+		name    string
+		options RateLimitOptions
+		start   time.Duration // from clockStart
+		steps   []limiterStep
+	}{
+		{
+			name:    "token bucket refills fractionally",
+			options: RateLimitOptions{Algorithm: AlgorithmTokenBucket, Limit: 2, Window: time.Second},
+			steps: []limiterStep{
+				{0, allowed(2, 1, 500*ms)},
+				{0, allowed(2, 0, time.Second)},
+				{0, rejected(2, time.Second, 500*ms)},
+				{500 * ms, allowed(2, 0, time.Second)},
+				{250 * ms, rejected(2, 750*ms, 250*ms)},
+				// A long pause refills no more than the burst.
+				{time.Hour, allowed(2, 1, 500*ms)},
+			},
+		},
+		{
+			name:    "token bucket burst",
+			options: RateLimitOptions{Algorithm: AlgorithmTokenBucket, Limit: 1, Window: time.Second, Burst: 3},
+			steps: []limiterStep{
+				{0, allowed(3, 2, time.Second)},
+				{0, allowed(3, 1, 2*time.Second)},
+				{0, allowed(3, 0, 3*time.Second)},
+				{0, rejected(3, 3*time.Second, time.Second)},
+			},
+		},
+		{
+			name:    "leaky bucket drains",
+			options: RateLimitOptions{Algorithm: AlgorithmLeakyBucket, Limit: 2, Window: time.Second},
+			steps: []limiterStep{
+				{0, allowed(2, 1, 500*ms)},
+				{0, allowed(2, 0, time.Second)},
+				{0, rejected(2, time.Second, 500*ms)},
+				{500 * ms, allowed(2, 0, time.Second)},
+				{time.Second, allowed(2, 1, 500*ms)},
+			},
+		},
+		{
+			name:    "fixed window resets at the boundary",
+			options: RateLimitOptions{Algorithm: AlgorithmFixedWindow, Limit: 2, Window: time.Second},
+			start:   800 * ms,
+			steps: []limiterStep{
+				{0, allowed(2, 1, 200*ms)},
+				{0, allowed(2, 0, 200*ms)},
+				{100 * ms, rejected(2, 100*ms, 100*ms)},
+				{100 * ms, allowed(2, 1, time.Second)},
+				{0, allowed(2, 0, time.Second)},
+				{999 * ms, rejected(2, ms, ms)},
+			},
+		},
+		{
+			name:    "sliding window log",
+			options: RateLimitOptions{Algorithm: AlgorithmSlidingWindowLog, Limit: 2, Window: time.Second},
+			steps: []limiterStep{
+				{0, allowed(2, 1, time.Second)},
+				{400 * ms, allowed(2, 0, time.Second)},
+				{0, rejected(2, time.Second, 600*ms)},
+				// The first request slides out exactly a window later.
+				{600 * ms, allowed(2, 0, time.Second)},
+				{0, rejected(2, time.Second, 400*ms)},
+			},
+		},
+		{
+			name:    "sliding window counter weights the previous window",
+			options: RateLimitOptions{Algorithm: AlgorithmSlidingWindowCounter, Limit: 4, Window: time.Second},
+			steps: []limiterStep{
+				{0, allowed(4, 3, 2*time.Second)},
+				{0, allowed(4, 2, 2*time.Second)},
+				{0, allowed(4, 1, 2*time.Second)},
+				{0, allowed(4, 0, 2*time.Second)},
+				{0, rejected(4, 2*time.Second, time.Second)},
+				// A quarter into the next window, 3 of the previous 4 count.
+				{1250 * ms, allowed(4, 0, 1750*ms)},
+				{0, rejected(4, 1750*ms, 250*ms)},
+				// Halfway, 2 of them do.
+				{250 * ms, allowed(4, 0, 1500*ms)},
+				// Two windows on, nothing counts.
+				{2 * time.Second, allowed(4, 3, 1500*ms)},
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			limiter, err := NewLimiter(c.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock := &fakeClock{t: clockStart.Add(c.start)}
+			limiter.(*keyedLimiter).now = clock.now
+			for i, step := range c.steps {
+				clock.t = clock.t.Add(step.advance)
+				if got := limiter.Allow("client"); got != step.want {
+					t.Errorf("step %d: got %+v, want %+v", i, got, step.want)
+				}
+			}
+			// Other keys have their own budget.
+			if d := limiter.Allow("other"); !d.Allowed {
+				t.Errorf("another key was rejected: %+v", d)
+			}
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	rl, err := NewRateLimitter(RateLimitOptions{Limit: 2, Window: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: clockStart}
+	rl.fallback.limiter.(*keyedLimiter).now = clock.now
+	h := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []struct {
+		advance                   time.Duration
+		status                    int
+		remaining, reset, retryAt string
+	}{
+		{0, http.StatusOK, "1", "30", ""},
+		{0, http.StatusOK, "0", "60", ""},
+		{0, http.StatusTooManyRequests, "0", "60", "30"},
+		// Partial seconds are rounded up.
+		{29500 * time.Millisecond, http.StatusTooManyRequests, "0", "31", "1"},
+		{500 * time.Millisecond, http.StatusOK, "0", "60", ""},
+	} {
+		clock.t = clock.t.Add(want.advance)
+		rec := get(h, "/teachers")
+		if rec.Code != want.status {
+			t.Errorf("request %d: status %d, want %d", i, rec.Code, want.status)
+		}
+		for name, value := range map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": want.remaining,
+			"RateLimit-Reset":     want.reset,
+			"Retry-After":         want.retryAt,
+		} {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("request %d: %s = %q, want %q", i, name, got, value)
+			}
+		}
+	}
+}
+
+// benchmarkKeys is the number of distinct clients in the benchmarks.
+const benchmarkKeys = 1024
+
+var benchmarkAlgorithms = []string{
+	AlgorithmTokenBucket,
+	AlgorithmLeakyBucket,
+	AlgorithmFixedWindow,
+	AlgorithmSlidingWindowLog,
+	AlgorithmSlidingWindowCounter,
+}
+
+// unlimited never rejects, so the benchmarks measure the bookkeeping rather
+// than the cost of writing 429 responses.
+func unlimited(algorithm string) RateLimitOptions {
+	options := RateLimitOptions{
+		Algorithm: algorithm,
+		Limit:     1 << 30,
+		Window:    time.Second,
+	}
+	if algorithm == AlgorithmSlidingWindowLog {
+		// The log keeps one timestamp per allowed request, so give it a
+		// limit it can actually reach.
+		options.Limit = 1000
+	}
+	return options
+}
+
+func keyNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = "10.0." + strconv.Itoa(i/256%256) + "." + strconv.Itoa(i%256)
+	}
+	return names
+}
+
+// BenchmarkAllow measures every algorithm with many clients and with all
+// requests contending for one key. Run it with -cpu to vary the parallelism:
+//
+//	go test ./internal/api/middlewares -run '^$' -bench Allow -benchmem -cpu 1,4,8
+func BenchmarkAllow(b *testing.B) {
+	for _, algorithm := range benchmarkAlgorithms {
+		for _, keys := range []int{benchmarkKeys, 1} {
+			name := algorithm + "/distinct-keys"
+			if keys == 1 {
+				name = algorithm + "/one-key"
+			}
+			b.Run(name, func(b *testing.B) {
+				limiter, err := NewLimiter(unlimited(algorithm))
+				if err != nil {
+					b.Fatal(err)
+				}
+				benchmarkLimiter(b, limiter, keys)
+			})
+		}
+	}
+}
+
+func benchmarkLimiter(b *testing.B, limiter Limiter, keys int) {
+	names := keyNames(keys)
+	var next atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := next.Add(1)
+		for pb.Next() {
+			limiter.Allow(names[i%uint64(len(names))])
+			i++
+		}
+	})
+}
+
+// BenchmarkMiddleware compares the rate limiting middleware with no limiter
+// and with the original one, which held its mutex while the handler ran.
+// The handler does some work so that cost shows up in the throughput.
+func BenchmarkMiddleware(b *testing.B) {
+	// Spin rather than sleep, since sleeps this short are rounded up by the
+	// scheduler.
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for start := time.Now(); time.Since(start) < 50*time.Microsecond; {
+		}
+	})
+	rl, err := NewRateLimitter(unlimited(AlgorithmTokenBucket))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("rate-limited", func(b *testing.B) { benchmarkHandler(b, rl.Middleware(handler)) })
+	b.Run("lock-held-across-handler", func(b *testing.B) { benchmarkHandler(b, lockAcrossHandler(handler)) })
+	b.Run("no-limiter", func(b *testing.B) { benchmarkHandler(b, handler) })
+}
+
+func benchmarkHandler(b *testing.B, handler http.Handler) {
+	addrs := keyNames(benchmarkKeys)
+	for i := range addrs {
+		addrs[i] += ":443"
+	}
+	var next atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := next.Add(1)
+		for pb.Next() {
+			req := httptest.NewRequest(http.MethodGet, "/teachers", nil)
+			req.RemoteAddr = addrs[i%uint64(len(addrs))]
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			i++
+		}
+	})
+}
+
+// lockAcrossHandler reproduces the original rate limiter, which held its
+// mutex while the wrapped handler ran.
+func lockAcrossHandler(next http.Handler) http.Handler {
+	var mu sync.Mutex
+	visitors := make(map[string]int)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		visitors[r.RemoteAddr]++
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// RequireIfMatch rejects unsafe requests that do not carry an If-Match header
+// with 428 Precondition Required. Wrap only the routes that must be edited
+// with optimistic concurrency; the handlers honour If-Match either way.
+func RequireIfMatch(next http.Handler) http.Handler {
+	fmt.Println("Require If-Match Middleware...")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if r.Header.Get("If-Match") == "" {
+				http.Error(w, "If-Match header required", http.StatusPreconditionRequired)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"restapi/internal/quota"
+)
+
+// Quota enforces the daily and monthly quotas of authenticated API keys and
+// counts their usage per route group, as named by groupOf from the matched
+// route (e.g. "students" for /students/7); requests matching no route count
+// as "other". Requests over quota are rejected with 429. Anonymous requests
+// are not counted.
+//
+// It belongs after the access checks of each route group, so requests they
+// reject are not billed.
+//
+// Every counted response carries X-Quota-Limit-<Period>,
+// X-Quota-Remaining-<Period> and X-Quota-Reset-<Period> headers, the reset
+// being in seconds, for each period that is limited.
+func Quota(store quota.Store, groupOf func(*http.Request) string) func(http.Handler) http.Handler {
+	fmt.Println("Quota Middleware...")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := CurrentAPIKey(r)
+			if key == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now()
+			group := groupOf(r)
+			if group == "" || group == quota.Total {
+				group = otherQuotaGroup
+			}
+			check := quota.NewCheck(key.Name, group, key.Quota)
+			ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+			allowed, statuses, err := store.Consume(ctx, check, now)
+			cancel()
+			if err != nil {
+				// Quotas are for billing, not protection; the rate limiter
+				// still guards the server, so let the request through.
+				slog.ErrorContext(r.Context(), "Error counting usage", "key", key.Name, "err", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			retryAfter := time.Duration(0)
+			for _, status := range statuses {
+				if status.Limit == 0 {
+					continue
+				}
+				period := strings.ToUpper(status.Period[:1]) + status.Period[1:]
+				reset := status.Reset.Sub(now)
+				w.Header().Set("X-Quota-Limit-"+period, strconv.Itoa(status.Limit))
+				w.Header().Set("X-Quota-Remaining-"+period, strconv.Itoa(status.Remaining()))
+				w.Header().Set("X-Quota-Reset-"+period, strconv.Itoa(seconds(reset)))
+				if status.Remaining() == 0 {
+					retryAfter = max(retryAfter, reset)
+				}
+			}
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+				http.Error(w, "Quota exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// otherQuotaGroup counts the requests that match no route group.
+const otherQuotaGroup = "other"
+
+// RouteGroup is the first segment of the request path, which groups a
+// resource's routes together for caching.
+func RouteGroup(r *http.Request) string {
+	group, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if group == "" {
+		return "root"
+	}
+	return group
+}
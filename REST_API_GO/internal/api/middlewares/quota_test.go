@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"restapi/internal/quota"
+)
+
+func TestQuota(t *testing.T) {
+	store, err := quota.NewMemoryStore("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := map[string]string{"/students": "students", "/total": "", "/nowhere": ""}
+	h := Quota(store, func(r *http.Request) string { return groups[r.URL.Path] })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	key := &APIKey{Name: "acme", Quota: quota.Limits{Day: 3, Groups: map[string]quota.Limits{"students": {Day: 1}}}}
+
+	send := func(path string, key *APIKey) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != nil {
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyKey, key))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := send("/students", key)
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Quota-Limit-Day") != "1" || rec.Header().Get("X-Quota-Remaining-Day") != "0" {
+		t.Errorf("first request: %d %v", rec.Code, rec.Header())
+	}
+	if reset, err := strconv.Atoi(rec.Header().Get("X-Quota-Reset-Day")); err != nil || reset <= 0 || reset > 86400 {
+		t.Errorf("X-Quota-Reset-Day = %q", rec.Header().Get("X-Quota-Reset-Day"))
+	}
+	if rec.Header().Get("X-Quota-Limit-Month") != "" {
+		t.Error("headers sent for the unlimited month")
+	}
+	rec = send("/students", key)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != rec.Header().Get("X-Quota-Reset-Day") {
+		t.Errorf("over the group quota: %d Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Anonymous requests are neither limited nor counted.
+	for range 5 {
+		if rec := send("/students", nil); rec.Code != http.StatusOK || rec.Header().Get("X-Quota-Limit-Day") != "" {
+			t.Fatalf("anonymous request: %d %v", rec.Code, rec.Header())
+		}
+	}
+
+	// Requests without a group are counted as other, never as the total.
+	send("/total", key)
+	send("/nowhere", key)
+	usage, err := store.Usage(context.Background(), "acme", quota.Day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].Groups[quota.Total] != 3 || usage[0].Groups["students"] != 1 || usage[0].Groups[otherQuotaGroup] != 2 {
+		t.Errorf("usage = %+v", usage)
+	}
+	if rec := send("/nowhere", key); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over the daily total: %d", rec.Code)
+	}
+}
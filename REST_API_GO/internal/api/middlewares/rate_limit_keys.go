@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// KeyFunc picks the bucket a request is counted against. Keys are prefixed
+// with their kind so different strategies never share a bucket. An empty key
+// means the strategy does not apply to the request, and the client IP is used
+// instead.
+type KeyFunc func(r *http.Request) string
+
+// KeyByClientIP counts requests per client address, as resolved by RealIP.
+func KeyByClientIP(r *http.Request) string {
+	return "ip:" + ClientIP(r)
+}
+
+// KeyByUser counts requests per authenticated user.
+func KeyByUser(r *http.Request) string {
+	if user := User(r); user != "" {
+		return "user:" + user
+	}
+	return ""
+}
+
+// KeyByAPIKey counts requests per API key sent in the X-API-Key header. The
+// key is hashed so the secret itself is never kept by the limiter.
+func KeyByAPIKey(r *http.Request) string {
+	apiKey := r.Header.Get("X-API-Key")
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return "key:" + hex.EncodeToString(sum[:8])
+}
+
+// KeyByRoute counts requests per route pattern of mux, so that all clients
+// share one budget per endpoint.
+func KeyByRoute(mux *http.ServeMux) KeyFunc {
+	return func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return "route:" + pattern
+		}
+		return ""
+	}
+}
+
+// CompositeKey combines several strategies, e.g. a budget per user and route.
+// If any of them does not apply, the request falls back to its client IP.
+func CompositeKey(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			if parts[i] = key(r); parts[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(parts, "|")
+	}
+}
@@ -0,0 +1,181 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps rate limit state in Redis, or anything that speaks its
+// protocol, so that every replica enforces the same budget. Each algorithm
+// is a Lua script, which Redis runs atomically, so concurrent requests from
+// different replicas can't interleave between reading and updating a key.
+//
+// Times are passed in from the caller in milliseconds, so the replicas'
+// clocks should be reasonably in sync.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Every script returns {allowed, remaining, reset_ms, retry_after_ms}.
+var (
+	tokenBucketScript = redis.NewScript(`
+local now, capacity, rate = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed, retry = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+local reset = math.ceil((capacity - tokens) / rate)
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens), reset, retry}
+`)
+
+	leakyBucketScript = redis.NewScript(`
+local now, capacity, rate = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'level', 'ts')
+local level = tonumber(state[1]) or 0
+local ts = tonumber(state[2]) or now
+level = math.max(0, level - math.max(0, now - ts) * rate)
+local allowed, retry = 0, 0
+if level + 1 <= capacity then
+	level = level + 1
+	allowed = 1
+else
+	retry = math.ceil((level + 1 - capacity) / rate)
+end
+local reset = math.ceil(level / rate)
+redis.call('HSET', KEYS[1], 'level', tostring(level), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(capacity - level), reset, retry}
+`)
+
+	fixedWindowScript = redis.NewScript(`
+local now, window, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local start = now - (now % window)
+local key = KEYS[1]
+local count = tonumber(redis.call('GET', key) or '0')
+local reset = start + window - now
+local allowed, retry = 0, 0
+if count < limit then
+	count = redis.call('INCR', key)
+	if count == 1 then
+		redis.call('PEXPIRE', key, window)
+	end
+	allowed = 1
+else
+	retry = reset
+end
+return {allowed, limit - count, reset, retry}
+`)
+
+	slidingWindowLogScript = redis.NewScript(`
+local now, window, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed, retry = 0, 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+else
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	retry = tonumber(oldest[2]) + window - now
+end
+redis.call('PEXPIRE', KEYS[1], window)
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+local reset = 0
+if newest[2] then
+	reset = tonumber(newest[2]) + window - now
+end
+return {allowed, limit - count, reset, retry}
+`)
+
+	slidingWindowCounterScript = redis.NewScript(`
+local now, window, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local start = now - (now % window)
+local currentKey = KEYS[1]
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local current = tonumber(redis.call('GET', currentKey) or '0')
+local estimate = previous * (1 - (now - start) / window) + current
+local allowed, retry = 0, 0
+if estimate + 1 <= limit then
+	current = redis.call('INCR', currentKey)
+	redis.call('PEXPIRE', currentKey, 2 * window)
+	estimate = estimate + 1
+	allowed = 1
+elseif previous > 0 and current + 1 <= limit then
+	retry = math.ceil((estimate + 1 - limit) / previous * window)
+else
+	retry = start + window - now
+end
+return {allowed, math.max(0, math.floor(limit - estimate)), start + 2 * window - now, retry}
+`)
+)
+
+// windowKey names the counter of the window starting at start, in unix
+// milliseconds. The scripts only touch keys passed in KEYS, as Redis Cluster
+// requires, and the hash tag keeps every window of a key in the same slot.
+func windowKey(key string, start int64) string {
+	return "{" + key + "}:" + strconv.FormatInt(start, 10)
+}
+
+func (s *RedisStore) Allow(ctx context.Context, options RateLimitOptions, key string, now time.Time) (Decision, error) {
+	nowMs := now.UnixMilli()
+	windowMs := options.Window.Milliseconds()
+	perMs := float64(options.Limit) / float64(windowMs)
+
+	var script *redis.Script
+	var args []any
+	keys := []string{key}
+	limit := options.Limit
+	switch options.Algorithm {
+	case AlgorithmTokenBucket, "":
+		script, args, limit = tokenBucketScript, []any{nowMs, options.Burst, perMs}, options.Burst
+	case AlgorithmLeakyBucket:
+		script, args, limit = leakyBucketScript, []any{nowMs, options.Burst, perMs}, options.Burst
+	case AlgorithmFixedWindow:
+		start := nowMs - nowMs%windowMs
+		script, args, keys = fixedWindowScript, []any{nowMs, windowMs, options.Limit}, []string{windowKey(key, start)}
+	case AlgorithmSlidingWindowLog:
+		// Every logged request needs a distinct member.
+		member := strconv.FormatInt(now.UnixNano(), 36) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+		script, args = slidingWindowLogScript, []any{nowMs, windowMs, options.Limit, member}
+	case AlgorithmSlidingWindowCounter:
+		start := nowMs - nowMs%windowMs
+		script, args = slidingWindowCounterScript, []any{nowMs, windowMs, options.Limit}
+		keys = []string{windowKey(key, start), windowKey(key, start-windowMs)}
+	default:
+		return Decision{}, fmt.Errorf("unknown rate limit algorithm %q", options.Algorithm)
+	}
+
+	result, err := script.Run(ctx, s.client, keys, args...).Int64Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(result) != 4 {
+		return Decision{}, fmt.Errorf("rate limit script returned %d values", len(result))
+	}
+	return Decision{
+		Allowed:    result[0] == 1,
+		Limit:      limit,
+		Remaining:  int(result[1]),
+		Reset:      time.Duration(result[2]) * time.Millisecond,
+		RetryAfter: time.Duration(result[3]) * time.Millisecond,
+	}, nil
+}
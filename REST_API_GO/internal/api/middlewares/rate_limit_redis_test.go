@@ -0,0 +1,135 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newRedisStore(t *testing.T) (*miniredis.Miniredis, *RedisStore) {
+	t.Helper()
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return m, NewRedisStore(client)
+}
+
+func TestRedisStoreEnforcesLimit(t *testing.T) {
+	for _, algorithm := range benchmarkAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			_, store := newRedisStore(t)
+			limiter, err := NewSharedLimiter(store, "test", RateLimitOptions{Algorithm: algorithm, Limit: 3, Window: time.Minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				if d := limiter.Allow("client"); !d.Allowed {
+					t.Fatalf("request %d rejected: %+v", i+1, d)
+				}
+			}
+			d := limiter.Allow("client")
+			if d.Allowed {
+				t.Fatalf("request over the limit allowed: %+v", d)
+			}
+			if d.RetryAfter <= 0 {
+				t.Errorf("RetryAfter = %s, want > 0", d.RetryAfter)
+			}
+			if d := limiter.Allow("other"); !d.Allowed {
+				t.Errorf("other key rejected: %+v", d)
+			}
+		})
+	}
+}
+
+func TestRedisStoreSharesStateBetweenLimiters(t *testing.T) {
+	_, store := newRedisStore(t)
+	options := RateLimitOptions{Algorithm: AlgorithmFixedWindow, Limit: 2, Window: time.Minute}
+	// Two replicas, each with its own limiter over the same store.
+	a, err := NewSharedLimiter(store, "shared", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSharedLimiter(store, "shared", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Allow("client")
+	b.Allow("client")
+	if d := a.Allow("client"); d.Allowed {
+		t.Fatalf("third request across replicas allowed: %+v", d)
+	}
+}
+
+// TestRedisStoreClusterKeys checks every key a script writes was passed in
+// KEYS with the client's hash tag, so the scripts work on Redis Cluster.
+func TestRedisStoreClusterKeys(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmFixedWindow, AlgorithmSlidingWindowCounter} {
+		t.Run(algorithm, func(t *testing.T) {
+			m, store := newRedisStore(t)
+			limiter, err := NewSharedLimiter(store, "test", RateLimitOptions{Algorithm: algorithm, Limit: 3, Window: time.Minute})
+			if err != nil {
+				t.Fatal(err)
+			}
+			limiter.Allow("client")
+			keys := m.Keys()
+			if len(keys) == 0 {
+				t.Fatal("no keys written")
+			}
+			for _, key := range keys {
+				if !strings.HasPrefix(key, "{ratelimit:test:"+algorithm+":client}:") {
+					t.Errorf("key %q is not tagged with the client key", key)
+				}
+			}
+		})
+	}
+}
+
+func TestSharedLimiterFailOpen(t *testing.T) {
+	m, store := newRedisStore(t)
+	limiter, err := NewSharedLimiter(store, "test", RateLimitOptions{Limit: 2, Window: time.Minute, FailurePolicy: FailOpen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetError("connection lost")
+
+	// The replica's own limits take over.
+	for i := 0; i < 2; i++ {
+		if d := limiter.Allow("client"); !d.Allowed || d.Unavailable {
+			t.Fatalf("request %d while the store is down: %+v", i+1, d)
+		}
+	}
+	if d := limiter.Allow("client"); d.Allowed {
+		t.Fatalf("local limit not enforced while the store is down: %+v", d)
+	}
+}
+
+func TestSharedLimiterFailClosed(t *testing.T) {
+	m, store := newRedisStore(t)
+	rl, err := NewRateLimitter(RateLimitOptions{Limit: 2, Window: time.Minute, FailurePolicy: FailClosed, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/teachers", nil))
+		return rec
+	}
+
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Fatalf("status with the store up = %d, want 200", rec.Code)
+	}
+	m.SetError("connection lost")
+	rec := serve()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status with the store down = %d, want 503", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("503 without Retry-After")
+	}
+}
@@ -0,0 +1,86 @@
+package middlewares
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Failure policies for when the shared RateLimitStore cannot be reached.
+const (
+	// FailOpen keeps serving, enforcing each replica's own limits instead.
+	FailOpen = "open"
+	// FailClosed rejects every request with 503 until the store is back.
+	FailClosed = "closed"
+)
+
+const (
+	// storeTimeout bounds how long a request waits for the shared store.
+	storeTimeout = 100 * time.Millisecond
+	// storeRetryInterval is how long the store is left alone after a
+	// failure, so an outage doesn't add storeTimeout to every request.
+	storeRetryInterval = 5 * time.Second
+)
+
+// RateLimitStore holds the per-key state of the rate limiting algorithms
+// outside the process, so that several replicas share one budget.
+type RateLimitStore interface {
+	Allow(ctx context.Context, options RateLimitOptions, key string, now time.Time) (Decision, error)
+}
+
+// NewSharedLimiter returns a Limiter backed by store. name separates the
+// state of limiters that use the same store, such as route overrides; the
+// algorithm is part of the key too, so changing it never reads state written
+// by another algorithm.
+func NewSharedLimiter(store RateLimitStore, name string, options RateLimitOptions) (Limiter, error) {
+	options, err := checkOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	local, err := NewLimiter(options)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedLimiter{
+		store:   store,
+		prefix:  "ratelimit:" + name + ":" + options.Algorithm + ":",
+		options: options,
+		local:   local,
+	}, nil
+}
+
+type sharedLimiter struct {
+	store   RateLimitStore
+	prefix  string
+	options RateLimitOptions
+	// local takes over under the FailOpen policy.
+	local Limiter
+	// downUntil is when the store may be tried again, in unix nanoseconds.
+	downUntil atomic.Int64
+}
+
+func (l *sharedLimiter) Allow(key string) Decision {
+	now := time.Now()
+	if now.UnixNano() >= l.downUntil.Load() {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		d, err := l.store.Allow(ctx, l.options, l.prefix+key, now)
+		cancel()
+		if err == nil {
+			return d
+		}
+		// Only the request that notices the outage logs it.
+		if old := l.downUntil.Load(); old <= now.UnixNano() && l.downUntil.CompareAndSwap(old, now.Add(storeRetryInterval).UnixNano()) {
+			log.Printf("Rate limit store unavailable, failing %s for %s: %v", l.options.FailurePolicy, storeRetryInterval, err)
+		}
+	}
+
+	if l.options.FailurePolicy == FailClosed {
+		return Decision{
+			Limit:       l.options.Limit,
+			RetryAfter:  time.Duration(l.downUntil.Load() - now.UnixNano()),
+			Unavailable: true,
+		}
+	}
+	return l.local.Allow(key)
+}
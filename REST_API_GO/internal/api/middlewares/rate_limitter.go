@@ -2,63 +2,139 @@ package middlewares
 
 import (
 	"fmt"
-	"net"
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
 )
 
 type rateLimitter struct {
-	mu        sync.Mutex
-	visitors  map[string]int
-	limit     int
-	resetTime time.Duration
+	fallback routeLimit
+	// routes matches requests against the patterns of the route overrides.
+	routes    *http.ServeMux
+	overrides map[string]routeLimit
 }
 
-func NewRateLimitter(limit int, resetTime time.Duration) *rateLimitter {
-	r1 := &rateLimitter{
-		visitors:  make(map[string]int),
-		limit:     limit,
-		resetTime: resetTime,
+type routeLimit struct {
+	limiter Limiter
+	key     KeyFunc
+}
+
+// NewRateLimitter builds a rate limiter using the algorithm and key strategy
+// selected in options, with separate budgets for the overridden routes.
+func NewRateLimitter(options RateLimitOptions) (*rateLimitter, error) {
+	if options.Key == nil {
+		options.Key = KeyByClientIP
+	}
+	fallback, err := newRouteLimit("default", options)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rateLimitter{
+		fallback:  fallback,
+		routes:    http.NewServeMux(),
+		overrides: make(map[string]routeLimit),
+	}
+	for pattern, override := range options.Routes {
+		limit, err := newRouteLimit(pattern, inherit(override, options))
+		if err != nil {
+			return nil, fmt.Errorf("rate limit for %q: %w", pattern, err)
+		}
+		if err := matchOnly(r.routes, pattern); err != nil {
+			return nil, err
+		}
+		r.overrides[pattern] = limit
 	}
-	go r1.resetVisitorCounts()
-	return r1
+	return r, nil
 }
 
-func (r *rateLimitter) resetVisitorCounts() {
-	for {
-		time.Sleep(r.resetTime)
-		r.mu.Lock()
-		for k := range r.visitors {
-			delete(r.visitors, k)
+// matchOnly registers pattern on a mux that is only used for matching,
+// turning the panic ServeMux raises for invalid patterns into an error.
+func matchOnly(mux *http.ServeMux, pattern string) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("invalid route %q: %v", pattern, rec)
 		}
-		r.mu.Unlock()
+	}()
+	mux.Handle(pattern, http.NotFoundHandler())
+	return nil
+}
+
+func newRouteLimit(name string, options RateLimitOptions) (routeLimit, error) {
+	var limiter Limiter
+	var err error
+	if options.Store != nil {
+		limiter, err = NewSharedLimiter(options.Store, name, options)
+	} else {
+		limiter, err = NewLimiter(options)
+	}
+	if err != nil {
+		return routeLimit{}, err
+	}
+	return routeLimit{limiter: limiter, key: options.Key}, nil
+}
+
+// inherit fills the zero fields of a route override from the defaults.
+func inherit(override, defaults RateLimitOptions) RateLimitOptions {
+	if override.Algorithm == "" {
+		override.Algorithm = defaults.Algorithm
+	}
+	if override.Limit == 0 {
+		override.Limit = defaults.Limit
+	}
+	if override.Window == 0 {
+		override.Window = defaults.Window
+	}
+	if override.Burst == 0 && override.Limit == defaults.Limit {
+		override.Burst = defaults.Burst
 	}
+	if override.Key == nil {
+		override.Key = defaults.Key
+	}
+	override.Store = defaults.Store
+	override.FailurePolicy = defaults.FailurePolicy
+	return override
 }
 
 func (r *rateLimitter) Middleware(next http.Handler) http.Handler {
-	fmt.Println("HPP Middleware...")
+	fmt.Println("Rate Limiter Middleware...")
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		fmt.Println("---HPP Middleware start---")
-		r.mu.Lock()
-		defer r.mu.Unlock()
-
-		ip, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err != nil {
-			ip = req.RemoteAddr // fallback if parsing fails
+		limit := r.fallback
+		if _, pattern := r.routes.Handler(req); pattern != "" {
+			limit = r.overrides[pattern]
 		}
-
-		if _, exists := r.visitors[ip]; !exists {
-			r.visitors[ip] = 0
+		key := limit.key(req)
+		if key == "" {
+			key = KeyByClientIP(req)
 		}
 
-		if r.visitors[ip] >= r.limit {
+		decision := limit.limiter.Allow(key)
+		if decision.Unavailable {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(decision.RetryAfter)))
+			http.Error(w, "Rate limiter unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		setRateLimitHeaders(w.Header(), decision)
+		if !decision.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(decision.RetryAfter)))
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
 
-		r.visitors[ip]++
 		next.ServeHTTP(w, req)
-		fmt.Println("---HPP Middleware end---")
 	})
 }
+
+// setRateLimitHeaders advertises the limit using the RateLimit header fields
+// of the IETF httpapi draft.
+func setRateLimitHeaders(h http.Header, d Decision) {
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(max(0, d.Remaining)))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+}
+
+// seconds rounds d up to whole seconds, as the headers only carry integers.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
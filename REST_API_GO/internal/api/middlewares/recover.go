@@ -0,0 +1,90 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Panics counts the handler panics Recover caught. It is published with
+// expvar as "http_panics".
+var Panics = expvar.NewInt("http_panics")
+
+// Recover turns a panicking handler into a JSON 500 response carrying the
+// request ID, and logs the panic with its stack trace. If the handler had
+// already started its response, the connection is aborted instead so the
+// client sees the response is incomplete. Panics with http.ErrAbortHandler
+// are deliberate aborts and are passed on untouched.
+func Recover(next http.Handler) http.Handler {
+	fmt.Println("Recover Middleware...")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := newResponseWritter(w, "")
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			stack := debug.Stack()
+			if hp, ok := rec.(*handlerPanic); ok {
+				rec, stack = hp.value, hp.stack
+			}
+			logPanic(r, rec, stack)
+			if rw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+
+			// Drop what the handler meant to send with its response.
+			h := w.Header()
+			for _, name := range []string{"Content-Encoding", "Content-Length", "Content-Disposition", "ETag", "Location"} {
+				h.Del(name)
+			}
+			writeJSONError(w, r, http.StatusInternalServerError, "Internal Server Error")
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// logPanic counts and logs a panic that happened serving r.
+func logPanic(r *http.Request, value any, stack []byte) {
+	Panics.Add(1)
+	slog.ErrorContext(r.Context(), "Panic serving request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"panic", fmt.Sprint(value),
+		"stack", string(stack))
+}
+
+// handlerPanic carries a panic from a goroutine running the handler, such as
+// Timeout's, back to the request's goroutine along with the stack it
+// happened on.
+type handlerPanic struct {
+	value any
+	stack []byte
+}
+
+func (p *handlerPanic) String() string {
+	return fmt.Sprint(p.value)
+}
+
+// writeJSONError answers r with status and a JSON body carrying message and
+// the request ID.
+func writeJSONError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Status    string `json:"status"`
+		Message   string `json:"message"`
+		RequestID string `json:"requestId"`
+	}{
+		Status:    "error",
+		Message:   message,
+		RequestID: RequestID(r),
+	})
+}
@@ -0,0 +1,172 @@
+package middlewares
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// maxRequestIDLength bounds client supplied request IDs, which end up in
+// logs and the audit trail.
+const maxRequestIDLength = 128
+
+// RequestIDs gives every request an ID: the client's X-Request-ID if it is
+// sensible, else the trace ID of a W3C traceparent header, else a random
+// one in the same 32 hex digit format. The ID is stored in the request
+// context for RequestID, echoed in the X-Request-ID response header and
+// appended to plain text error bodies, so a user's report can be matched to
+// the logs.
+func RequestIDs(next http.Handler) http.Handler {
+	fmt.Println("Request ID Middleware...")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = traceID(r.Header.Get("traceparent"))
+		}
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		rw := &requestIDWriter{ResponseWriter: w, id: id}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+		rw.finish()
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// traceID returns the trace ID of a version 00 traceparent header such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", or "".
+func traceID(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ""
+	}
+	if !isLowerHex(parts[1]) || !isLowerHex(parts[2]) || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	return parts[1]
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDWriter notices plain text error responses so the request ID can
+// be added to their body.
+type requestIDWriter struct {
+	http.ResponseWriter
+	id          string
+	wroteHeader bool
+	errorBody   bool
+	hijacked    bool
+}
+
+func (rw *requestIDWriter) WriteHeader(code int) {
+	if !rw.wroteHeader && code >= 200 {
+		rw.wroteHeader = true
+		h := rw.Header()
+		// Leave encoded bodies alone; appending to them would corrupt them.
+		rw.errorBody = code >= 400 && h.Get("Content-Encoding") == "" &&
+			strings.HasPrefix(h.Get("Content-Type"), "text/plain")
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *requestIDWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return rw.ResponseWriter.Write(b)
+}
+
+// finish appends the request ID to an error body, like
+//
+//	Teacher not found
+//	Request ID: 4bf92f3577b34da6a3ce929d0e0e4736
+func (rw *requestIDWriter) finish() {
+	if rw.errorBody && !rw.hijacked {
+		_, _ = io.WriteString(rw.ResponseWriter, "Request ID: "+rw.id+"\n")
+	}
+}
+
+// FlushError implements the flushing used by http.ResponseController.
+func (rw *requestIDWriter) FlushError() error {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	return http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Flush implements http.Flusher.
+func (rw *requestIDWriter) Flush() {
+	_ = rw.FlushError()
+}
+
+// Hijack implements http.Hijacker.
+func (rw *requestIDWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.hijacked = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *requestIDWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// LogHandler wraps a slog.Handler so that records logged with the context
+// of a request carry its request ID, e.g. through slog.ErrorContext.
+func LogHandler(h slog.Handler) slog.Handler {
+	return &logHandler{Handler: h}
+}
+
+type logHandler struct {
+	slog.Handler
+}
+
+func (h *logHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		record.AddAttrs(slog.String("requestId", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	return &logHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,98 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var generatedID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func TestRequestIDs(t *testing.T) {
+	var seen string
+	h := RequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r)
+	}))
+	const trace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceparent := "00-" + trace + "-00f067aa0ba902b7-01"
+
+	for _, c := range []struct {
+		name   string
+		header []string
+		want   string
+	}{
+		{"client ID", []string{"X-Request-ID", "client-1"}, "client-1"},
+		{"client ID over traceparent", []string{"X-Request-ID", "client-1", "traceparent", traceparent}, "client-1"},
+		{"traceparent", []string{"traceparent", traceparent}, trace},
+		{"invalid client ID falls back to traceparent", []string{"X-Request-ID", "has space", "traceparent", traceparent}, trace},
+		{"overlong client ID", []string{"X-Request-ID", strings.Repeat("a", maxRequestIDLength+1)}, ""},
+		{"control character", []string{"X-Request-ID", "a\x01b"}, ""},
+		{"uppercase traceparent", []string{"traceparent", "00-" + strings.ToUpper(trace) + "-00f067aa0ba902b7-01"}, ""},
+		{"all-zero trace ID", []string{"traceparent", "00-" + strings.Repeat("0", 32) + "-00f067aa0ba902b7-01"}, ""},
+		{"version ff", []string{"traceparent", "ff-" + trace + "-00f067aa0ba902b7-01"}, ""},
+		{"short span ID", []string{"traceparent", "00-" + trace + "-00f067aa-01"}, ""},
+		{"nothing", nil, ""},
+	} {
+		rec := get(h, "/", c.header...)
+		got := rec.Header().Get("X-Request-ID")
+		if got != seen {
+			t.Errorf("%s: header %q, context %q", c.name, got, seen)
+		}
+		if c.want == "" && !generatedID.MatchString(got) || c.want != "" && got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	a, b := get(h, "/").Header().Get("X-Request-ID"), get(h, "/").Header().Get("X-Request-ID")
+	if a == b {
+		t.Errorf("generated IDs repeat: %q", a)
+	}
+}
+
+func TestRequestIDErrorBody(t *testing.T) {
+	h := RequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "Teacher not found", http.StatusNotFound)
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = io.WriteString(w, `{"error":"bad"}`)
+		case "/gzip":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, "\x1f\x8b")
+		default:
+			_, _ = io.WriteString(w, "ok")
+		}
+	}))
+
+	if rec := get(h, "/missing", "X-Request-ID", "abc"); rec.Body.String() != "Teacher not found\nRequest ID: abc\n" {
+		t.Errorf("plain text error: %q", rec.Body)
+	}
+	for _, path := range []string{"/json", "/gzip", "/"} {
+		rec := get(h, path, "X-Request-ID", "abc")
+		if strings.Contains(rec.Body.String(), "Request ID") {
+			t.Errorf("%s: body changed to %q", path, rec.Body)
+		}
+	}
+}
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(LogHandler(slog.NewTextHandler(&buf, nil))).With("component", "test")
+
+	ctx := context.WithValue(context.Background(), requestIDKey, "abc")
+	logger.InfoContext(ctx, "with")
+	logger.Info("without")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "component=test requestId=abc") || strings.Contains(lines[1], "requestId") {
+		t.Errorf("log = %q", buf.String())
+	}
+}
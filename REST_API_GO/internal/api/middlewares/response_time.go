@@ -1,8 +1,11 @@
 package middlewares
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"time"
 )
@@ -11,28 +14,97 @@ func ResponseTimeMiddleware(next http.Handler) http.Handler {
 	fmt.Println("Response Time Middleware...")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("---Response Time Middleware start---")
-		start := time.Now()
-		wrappedWritter := &responseWritter{ResponseWriter: w, status: http.StatusOK}
-
-		// calculate the response time
-		duration := time.Since(start)
-		w.Header().Set("X-Response-Time", duration.String())
+		wrappedWritter := newResponseWritter(w, "X-Response-Time")
 		next.ServeHTTP(wrappedWritter, r)
 
 		// log the request details
-		duration = time.Since(start)
+		duration := time.Since(wrappedWritter.start)
 		log.Printf("Method %s, URL %s, Status %d, Duration %s", r.Method, r.URL.Path, wrappedWritter.status, duration)
 		fmt.Println("---Response Time Middleware end---")
 
 	})
 }
 
+// responseWritter records the status and size of the response. It passes
+// flushing, hijacking and http.ResponseController calls through to the
+// writer it wraps.
 type responseWritter struct {
 	http.ResponseWriter
-	status int
+	status      int
+	bytes       int64
+	wroteHeader bool
+	hijacked    bool
+	start       time.Time
+	// timeHeader, when set, is the header that reports how long the handler
+	// took until the response headers were sent.
+	timeHeader string
 }
 
-func (rw *responseWritter) WriteHeader(code int) {
+func newResponseWritter(w http.ResponseWriter, timeHeader string) *responseWritter {
+	return &responseWritter{ResponseWriter: w, status: http.StatusOK, start: time.Now(), timeHeader: timeHeader}
+}
+
+// sendHeader records that the headers are about to be sent, the last moment
+// the response time can still be added to them.
+func (rw *responseWritter) sendHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
 	rw.status = code
+	if rw.timeHeader != "" {
+		rw.Header().Set(rw.timeHeader, time.Since(rw.start).String())
+	}
+}
+
+func (rw *responseWritter) WriteHeader(code int) {
+	// Informational responses are followed by the final one.
+	if code >= 200 {
+		rw.sendHeader(code)
+	}
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWritter) Write(b []byte) (int, error) {
+	rw.sendHeader(http.StatusOK)
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's io.ReaderFrom, e.g. sendfile.
+func (rw *responseWritter) ReadFrom(src io.Reader) (int64, error) {
+	rw.sendHeader(http.StatusOK)
+	n, err := io.Copy(rw.ResponseWriter, src)
+	rw.bytes += n
+	return n, err
+}
+
+// FlushError implements the flushing used by http.ResponseController.
+func (rw *responseWritter) FlushError() error {
+	rw.sendHeader(http.StatusOK)
+	return http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Flush implements http.Flusher.
+func (rw *responseWritter) Flush() {
+	_ = rw.FlushError()
+}
+
+// Hijack implements http.Hijacker.
+func (rw *responseWritter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.hijacked = true
+		if !rw.wroteHeader {
+			rw.wroteHeader = true
+			rw.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWritter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
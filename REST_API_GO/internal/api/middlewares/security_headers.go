@@ -1,29 +1,242 @@
 package middlewares
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// SecurityOptions configures SecurityHeadersWithOptions. Empty fields leave
+// their header out.
+type SecurityOptions struct {
+	CSP CSP
+	// CSPReportOnly sends the policy as Content-Security-Policy-Report-Only,
+	// so violations are reported but not blocked.
+	CSPReportOnly bool
+	// ReportURI is where browsers send violation reports. The policy's
+	// report-uri and report-to directives and the Reporting-Endpoints header
+	// are set from it unless the policy already names an endpoint.
+	ReportURI string
+	// NELMaxAge enables Network Error Logging to ReportURI, which must then
+	// be an absolute https URL.
+	NELMaxAge time.Duration
+
+	// HSTSMaxAge enables Strict-Transport-Security.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	FrameOptions   string
+	ReferrerPolicy string
+	CacheControl   string
+	// PermissionsPolicy maps features to the origins allowed to use them:
+	// "self", "*" or an origin such as "https://example.com". An empty list
+	// disables the feature.
+	PermissionsPolicy map[string][]string
+
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+
+	// Routes replace the options for requests matching a ServeMux pattern,
+	// e.g. a docs UI that needs to load scripts. Derive them from the
+	// defaults to keep the rest of the policy.
+	Routes map[string]SecurityOptions
+}
+
+// DefaultSecurityOptions suit a JSON API that serves no pages of its own.
+var DefaultSecurityOptions = SecurityOptions{
+	CSP: CSP{}.
+		With(CSPDefaultSrc, CSPSelf).
+		With(CSPObjectSrc, CSPNone).
+		With(CSPBaseURI, CSPSelf).
+		With(CSPFrameAncestors, CSPNone),
+	HSTSMaxAge:            2 * 365 * 24 * time.Hour,
+	HSTSIncludeSubdomains: true,
+	HSTSPreload:           true,
+	FrameOptions:          "DENY",
+	ReferrerPolicy:        "no-referrer",
+	CacheControl:          "no-cache, no-store, must-revalidate",
+	PermissionsPolicy: map[string][]string{
+		"camera":      {},
+		"geolocation": {},
+		"microphone":  {},
+		"payment":     {},
+		"usb":         {},
+	},
+	CrossOriginOpenerPolicy:   "same-origin",
+	CrossOriginEmbedderPolicy: "require-corp",
+	CrossOriginResourcePolicy: "same-origin",
+	ReportURI:                 "/csp-report",
+}
+
+// reportGroup names the ReportURI endpoint in report-to and NEL.
+const reportGroup = "csp-endpoint"
+
+// SecurityHeaders applies DefaultSecurityOptions.
 func SecurityHeaders(next http.Handler) http.Handler {
+	return SecurityHeadersWithOptions(DefaultSecurityOptions)(next)
+}
+
+// SecurityHeadersWithOptions sets the security headers of every response.
+// When the policy uses CSPNonceSource, each request gets a fresh nonce, available
+// to handlers through CSPNonce.
+func SecurityHeadersWithOptions(options SecurityOptions) func(http.Handler) http.Handler {
 	fmt.Println("Security Headers Middleware...")
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("---Security Headers Middleware start---")
-		w.Header().Set("X-DNS-Prefetch-Control", "off")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "DENY")
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
-		w.Header().Set("Content-Security-Policy", "default-src 'self'")
-		w.Header().Set("Referrer-Policy", "no-referrer")
-		w.Header().Set("X-Powered-By", "Go")
-		w.Header().Set("Server", "")
-		w.Header().Set("X-Permitted-Cross-Domain-Policies", "none")
-		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-		w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
-		w.Header().Set("X-Origin-Embedder-Policy", "require-corp")
-		w.Header().Set("Access-Control-Max-Age", "86400")
-		next.ServeHTTP(w, r)
-		fmt.Println("---Security Headers Middleware end---")
-	})
+	fallback := newSecurityHeaders(options)
+	routes := http.NewServeMux()
+	overrides := make(map[string]*securityHeaders, len(options.Routes))
+	for pattern, route := range options.Routes {
+		routes.Handle(pattern, http.NotFoundHandler())
+		overrides[pattern] = newSecurityHeaders(route)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers := fallback
+			if _, pattern := routes.Handler(r); pattern != "" {
+				headers = overrides[pattern]
+			}
+			for name, value := range headers.static {
+				w.Header()[name] = slices.Clone(value)
+			}
+			if headers.csp.usesNonce() {
+				nonce, err := newNonce()
+				if err != nil {
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set(headers.cspHeader, headers.csp.String(nonce))
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// securityHeaders holds the headers rendered once from SecurityOptions; only
+// a policy with a nonce is rendered per request.
+type securityHeaders struct {
+	static    http.Header
+	csp       CSP
+	cspHeader string
+}
+
+func newSecurityHeaders(options SecurityOptions) *securityHeaders {
+	h := &securityHeaders{static: make(http.Header), csp: options.CSP, cspHeader: "Content-Security-Policy"}
+	set := func(name, value string) {
+		if value != "" {
+			h.static.Set(name, value)
+		}
+	}
+
+	set("X-Content-Type-Options", "nosniff")
+	set("X-DNS-Prefetch-Control", "off")
+	set("X-Permitted-Cross-Domain-Policies", "none")
+	set("X-Frame-Options", options.FrameOptions)
+	set("Referrer-Policy", options.ReferrerPolicy)
+	set("Cache-Control", options.CacheControl)
+	set("Cross-Origin-Opener-Policy", options.CrossOriginOpenerPolicy)
+	set("Cross-Origin-Embedder-Policy", options.CrossOriginEmbedderPolicy)
+	set("Cross-Origin-Resource-Policy", options.CrossOriginResourcePolicy)
+	set("Permissions-Policy", permissionsPolicy(options.PermissionsPolicy))
+
+	if options.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.Itoa(int(options.HSTSMaxAge.Seconds()))
+		if options.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if options.HSTSPreload {
+			hsts += "; preload"
+		}
+		set("Strict-Transport-Security", hsts)
+	}
+
+	if options.ReportURI != "" {
+		if !h.csp.Has(CSPReportURI) && !h.csp.Has(CSPReportTo) {
+			// report-uri for the browsers that predate the Reporting API.
+			h.csp = h.csp.With(CSPReportURI, options.ReportURI).With(CSPReportTo, reportGroup)
+		}
+		set("Reporting-Endpoints", reportGroup+"="+strconv.Quote(options.ReportURI))
+		if options.NELMaxAge > 0 {
+			maxAge := int(options.NELMaxAge.Seconds())
+			set("Report-To", jsonHeader(reportTo{
+				Group:     reportGroup,
+				MaxAge:    maxAge,
+				Endpoints: []reportEndpoint{{URL: options.ReportURI}},
+			}))
+			set("NEL", jsonHeader(networkErrorLogging{ReportTo: reportGroup, MaxAge: maxAge}))
+		}
+	}
+	if options.CSPReportOnly {
+		h.cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	if !h.csp.usesNonce() {
+		set(h.cspHeader, h.csp.String(""))
+	}
+	return h
+}
+
+// reportTo is the value of the Report-To header.
+type reportTo struct {
+	Group     string           `json:"group"`
+	MaxAge    int              `json:"max_age"`
+	Endpoints []reportEndpoint `json:"endpoints"`
+}
+
+type reportEndpoint struct {
+	URL string `json:"url"`
+}
+
+// networkErrorLogging is the value of the NEL header.
+type networkErrorLogging struct {
+	ReportTo string `json:"report_to"`
+	MaxAge   int    `json:"max_age"`
+}
+
+// jsonHeader renders v as a JSON header value. The values are plain structs
+// of strings and numbers, which always marshal.
+func jsonHeader(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// permissionsPolicy renders a Permissions-Policy header such as
+// `camera=(), geolocation=(self "https://maps.example.com")`.
+func permissionsPolicy(features map[string][]string) string {
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		origins := make([]string, 0, len(features[name]))
+		for _, origin := range features[name] {
+			if origin != "self" && origin != "*" {
+				origin = strconv.Quote(origin)
+			}
+			origins = append(origins, origin)
+		}
+		parts = append(parts, name+"=("+strings.Join(origins, " ")+")")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// newNonce returns 128 random bits, base64 encoded.
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
 }
@@ -0,0 +1,165 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// TimeoutOptions configures Timeout.
+type TimeoutOptions struct {
+	// Timeout is how long a handler may take; 0 means no deadline.
+	Timeout time.Duration
+	// Status answers requests that run out of time: 503 by default, or 504
+	// for routes that mostly wait on another service.
+	Status int
+	// Routes override the options per ServeMux pattern such as
+	// "GET /teachers/export". An unset Status is inherited.
+	Routes map[string]TimeoutOptions
+}
+
+// Timeout gives handlers a deadline through the request's context. The
+// response is held back until the handler returns; a handler still running
+// at the deadline has its response discarded and the client gets a JSON
+// error with the route's Status instead.
+//
+// The handler runs on its own goroutine, so Timeout must come after the
+// middlewares that record things for the access log, such as Authenticate.
+//
+// Routes without a deadline are not buffered, so they can stream, and have
+// the server's WriteTimeout lifted so a long stream is not cut off. Routes
+// with a deadline of their own may read their body until that deadline, in
+// place of the server's ReadTimeout, so a large upload given longer than
+// the rest is not cut off either.
+func Timeout(options TimeoutOptions) func(http.Handler) http.Handler {
+	fmt.Println("Timeout Middleware...")
+	if options.Status == 0 {
+		options.Status = http.StatusServiceUnavailable
+	}
+	routes := http.NewServeMux()
+	overrides := make(map[string]*TimeoutOptions, len(options.Routes))
+	for pattern, route := range options.Routes {
+		routes.Handle(pattern, http.NotFoundHandler())
+		if route.Status == 0 {
+			route.Status = options.Status
+		}
+		route.Routes = nil
+		overrides[pattern] = &route
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := &options
+			_, pattern := routes.Handler(r)
+			if pattern != "" {
+				route = overrides[pattern]
+			}
+			if route.Timeout <= 0 {
+				_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+				next.ServeHTTP(w, r)
+				return
+			}
+			if pattern != "" {
+				_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(route.Timeout))
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), route.Timeout)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{header: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						if p != http.ErrAbortHandler {
+							p = &handlerPanic{value: p, stack: debug.Stack()}
+						}
+						panicked <- p
+						return
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				h := w.Header()
+				clear(h)
+				for name, values := range tw.header {
+					h[name] = values
+				}
+				if tw.status == 0 {
+					tw.status = http.StatusOK
+				}
+				w.WriteHeader(tw.status)
+				_, _ = w.Write(tw.body.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				// The handler is still running. Nobody will re-panic what
+				// it panics with now, so log it here.
+				go func() {
+					select {
+					case p := <-panicked:
+						if hp, ok := p.(*handlerPanic); ok {
+							logPanic(r, hp.value, hp.stack)
+						}
+					case <-done:
+					}
+				}()
+				if ctx.Err() != context.DeadlineExceeded {
+					// The client went away; there is no one to answer.
+					return
+				}
+				writeJSONError(w, r, route.Status, "Request timed out")
+			}
+		})
+	}
+}
+
+// timeoutWriter holds a handler's response until Timeout decides whether to
+// send it.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
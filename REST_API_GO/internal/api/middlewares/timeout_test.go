@@ -0,0 +1,59 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTimeoutReadDeadline checks a route with a longer deadline may read its
+// body past the server's ReadTimeout, and other routes may not.
+func TestTimeoutReadDeadline(t *testing.T) {
+	srv := httptest.NewUnstartedServer(Timeout(TimeoutOptions{
+		Timeout: time.Second,
+		Routes:  map[string]TimeoutOptions{"POST /import": {Timeout: 5 * time.Second}},
+	})(echo))
+	srv.Config.ReadTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	// slowly sends its parts 100ms apart.
+	slowly := func(parts ...string) io.Reader {
+		pr, pw := io.Pipe()
+		go func() {
+			for _, part := range parts {
+				time.Sleep(100 * time.Millisecond)
+				if _, err := io.WriteString(pw, part); err != nil {
+					return
+				}
+			}
+			pw.Close()
+		}()
+		return pr
+	}
+	parts := []string{"a", "b", "c", "d", "e"}
+
+	resp, err := http.Post(srv.URL+"/import", "text/csv", slowly(parts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != strings.Join(parts, "") {
+		t.Errorf("import: got %d %q", resp.StatusCode, body)
+	}
+
+	// The read times out, which cancels the request; what the client gets
+	// back, if anything, is not the body.
+	resp, err = http.Post(srv.URL+"/teachers", "application/json", slowly(parts...))
+	if err == nil {
+		body, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) == strings.Join(parts, "") {
+			t.Error("default route read its body past ReadTimeout")
+		}
+	}
+}
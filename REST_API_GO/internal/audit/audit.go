@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+)
+
+// Entry describes one mutation of a stored record.
+type Entry struct {
+	ID        int               `json:"id"`
+	Time      time.Time         `json:"time"`
+	Actor     string            `json:"actor"`
+	RequestID string            `json:"requestId,omitempty"`
+	Action    string            `json:"action"`
+	Resource  string            `json:"resource"`
+	RecordID  int               `json:"recordId"`
+	Before    json.RawMessage   `json:"before"`
+	After     json.RawMessage   `json:"after"`
+	Diff      map[string]Change `json:"diff"`
+}
+
+// Change is the before and after value of a single top-level field.
+type Change struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// Filter selects entries in Log.Query. Zero fields match everything.
+type Filter struct {
+	Actor    string
+	Resource string
+	From     time.Time
+	To       time.Time
+}
+
+func (f Filter) matches(e Entry) bool {
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if f.Resource != "" && e.Resource != f.Resource {
+		return false
+	}
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.Time.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// NewEntry snapshots before and after and computes the field diff between
+// them. Either snapshot may be nil, e.g. before for a create.
+func NewEntry(action, resource string, recordID int, before, after any) (Entry, error) {
+	e := Entry{
+		Time:     time.Now().UTC(),
+		Action:   action,
+		Resource: resource,
+		RecordID: recordID,
+		Before:   json.RawMessage("null"),
+		After:    json.RawMessage("null"),
+	}
+	var err error
+	if before != nil {
+		if e.Before, err = json.Marshal(before); err != nil {
+			return Entry{}, err
+		}
+	}
+	if after != nil {
+		if e.After, err = json.Marshal(after); err != nil {
+			return Entry{}, err
+		}
+	}
+	e.Diff, err = diff(e.Before, e.After)
+	if err != nil {
+		return Entry{}, err
+	}
+	return e, nil
+}
+
+// diff compares two JSON objects field by field.
+func diff(before, after json.RawMessage) (map[string]Change, error) {
+	var b, a map[string]json.RawMessage
+	if err := json.Unmarshal(before, &b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &a); err != nil {
+		return nil, err
+	}
+
+	null := json.RawMessage("null")
+	changes := make(map[string]Change)
+	for k, bv := range b {
+		av, ok := a[k]
+		if !ok {
+			av = null
+		}
+		if !bytes.Equal(bv, av) {
+			changes[k] = Change{Before: bv, After: av}
+		}
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = Change{Before: null, After: av}
+		}
+	}
+	return changes, nil
+}
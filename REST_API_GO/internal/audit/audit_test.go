@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type record struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	Class     string `json:"class,omitempty"`
+}
+
+func TestNewEntryDiff(t *testing.T) {
+	for _, c := range []struct {
+		name          string
+		before, after any
+		want          map[string]Change
+	}{
+		{"create", nil, &record{ID: 1, FirstName: "Ada"}, map[string]Change{
+			"id":        {json.RawMessage("null"), json.RawMessage("1")},
+			"firstName": {json.RawMessage("null"), json.RawMessage(`"Ada"`)},
+		}},
+		{"update", &record{ID: 1, FirstName: "Ada", Class: "9A"}, &record{ID: 1, FirstName: "Grace"}, map[string]Change{
+			"firstName": {json.RawMessage(`"Ada"`), json.RawMessage(`"Grace"`)},
+			"class":     {json.RawMessage(`"9A"`), json.RawMessage("null")},
+		}},
+		{"purge", &record{ID: 1, FirstName: "Ada"}, nil, map[string]Change{
+			"id":        {json.RawMessage("1"), json.RawMessage("null")},
+			"firstName": {json.RawMessage(`"Ada"`), json.RawMessage("null")},
+		}},
+		{"no change", &record{ID: 1}, &record{ID: 1}, map[string]Change{}},
+	} {
+		e, err := NewEntry(ActionUpdate, "teachers", 1, c.before, c.after)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(e.Diff) != len(c.want) {
+			t.Errorf("%s: diff %s, want %d changes", c.name, diffString(e.Diff), len(c.want))
+			continue
+		}
+		for field, want := range c.want {
+			got := e.Diff[field]
+			if string(got.Before) != string(want.Before) || string(got.After) != string(want.After) {
+				t.Errorf("%s: %s changed %s -> %s, want %s -> %s", c.name, field, got.Before, got.After, want.Before, want.After)
+			}
+		}
+	}
+}
+
+func diffString(d map[string]Change) string {
+	b, _ := json.Marshal(d)
+	return string(b)
+}
+
+func TestQuery(t *testing.T) {
+	l, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, e := range []Entry{
+		{Actor: "ops", Resource: "teachers", Action: ActionCreate},
+		{Actor: "acme", Resource: "teachers", Action: ActionUpdate},
+		{Actor: "ops", Resource: "students", Action: ActionDelete},
+		{Actor: "system", Resource: "students", Action: ActionPurge},
+	} {
+		e.Time = start.Add(time.Duration(i) * time.Hour)
+		if err := l.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, c := range []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{"everything", Filter{}, []int{1, 2, 3, 4}},
+		{"actor", Filter{Actor: "ops"}, []int{1, 3}},
+		{"resource", Filter{Resource: "students"}, []int{3, 4}},
+		{"actor and resource", Filter{Actor: "ops", Resource: "students"}, []int{3}},
+		{"from, inclusive", Filter{From: start.Add(2 * time.Hour)}, []int{3, 4}},
+		{"to, inclusive", Filter{To: start.Add(time.Hour)}, []int{1, 2}},
+		{"between", Filter{From: start.Add(30 * time.Minute), To: start.Add(150 * time.Minute)}, []int{2, 3}},
+		{"no match", Filter{Actor: "nobody"}, []int{}},
+	} {
+		got := l.Query(c.filter)
+		ids := make([]int, len(got))
+		for i, e := range got {
+			ids[i] = e.ID
+		}
+		if len(ids) != len(c.want) || got == nil {
+			t.Errorf("%s: got %v, want %v", c.name, ids, c.want)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.want[i] {
+				t.Errorf("%s: got %v, want %v", c.name, ids, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEntry(ActionCreate, "teachers", 1, nil, &record{ID: 1, FirstName: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Actor = "ops"
+	if err := l.Append(e); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if err := reopened.Append(Entry{Actor: "ops", Action: ActionUpdate}); err != nil {
+		t.Fatal(err)
+	}
+	entries := reopened.Query(Filter{Actor: "ops"})
+	if len(entries) != 2 || entries[0].ID != 1 || entries[1].ID != 2 {
+		t.Fatalf("entries after reopening = %+v", entries)
+	}
+	if got := entries[0].Diff["firstName"].After; string(got) != `"Ada"` {
+		t.Errorf("reloaded diff of firstName = %s", got)
+	}
+}
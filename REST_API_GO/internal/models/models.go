@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Meta holds the bookkeeping fields shared by every stored record.
+type Meta struct {
+	ID      int `json:"id"`
+	Version int `json:"version"`
+	// DeletedAt is set when the record has been moved to the trash.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Metadata gives stores access to the embedded bookkeeping fields.
+func (m *Meta) Metadata() *Meta {
+	return m
+}
+
+// Record is satisfied by a pointer to any model that embeds Meta and can
+// validate itself.
+type Record[T any] interface {
+	*T
+	Metadata() *Meta
+	Validate() error
+}
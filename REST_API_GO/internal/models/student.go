@@ -0,0 +1,22 @@
+package models
+
+import "errors"
+
+type Student struct {
+	Meta
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Class     string `json:"class"`
+}
+
+func (s Student) Validate() error {
+	return errors.Join(
+		required(
+			"firstName", s.FirstName,
+			"lastName", s.LastName,
+			"class", s.Class,
+		),
+		validEmail("email", s.Email),
+	)
+}
@@ -0,0 +1,18 @@
+package models
+
+type Teacher struct {
+	Meta
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Class     string `json:"class"`
+	Subject   string `json:"subject"`
+}
+
+func (t Teacher) Validate() error {
+	return required(
+		"firstName", t.FirstName,
+		"lastName", t.LastName,
+		"class", t.Class,
+		"subject", t.Subject,
+	)
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+// FieldError reports a problem with one field of a record.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// FieldErrors flattens an error returned by Validate into its field errors.
+func FieldErrors(err error) []*FieldError {
+	var fieldErrors []*FieldError
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			fieldErrors = append(fieldErrors, FieldErrors(e)...)
+		}
+		return fieldErrors
+	}
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		return []*FieldError{fe}
+	}
+	if err != nil {
+		return []*FieldError{{Message: err.Error()}}
+	}
+	return nil
+}
+
+// required returns a FieldError for each named value that is blank.
+func required(fields ...string) error {
+	var errs []error
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.TrimSpace(fields[i+1]) == "" {
+			errs = append(errs, &FieldError{Field: fields[i], Message: "is required"})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validEmail(field, email string) error {
+	if email == "" {
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return &FieldError{Field: field, Message: "is not a valid email address"}
+	}
+	return nil
+}
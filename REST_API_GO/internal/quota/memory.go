@@ -0,0 +1,160 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dayRetention is how long daily counters are kept; monthly counters are
+// kept for billing indefinitely.
+const dayRetention = 90 * 24 * time.Hour
+
+type counterID struct {
+	Key    string `json:"key"`
+	Period string `json:"period"`
+	Start  string `json:"start"`
+}
+
+// MemoryStore counts usage in memory and, when given a path, persists the
+// counters to a JSON file every flushInterval. Up to one interval of usage
+// can be lost in a crash.
+type MemoryStore struct {
+	mu       sync.Mutex
+	counters map[counterID]map[string]int
+	path     string
+	dirty    bool
+}
+
+// NewMemoryStore loads the counters saved at path, if any, and starts
+// flushing them back every flushInterval. An empty path keeps them in memory.
+func NewMemoryStore(path string, flushInterval time.Duration) (*MemoryStore, error) {
+	s := &MemoryStore{
+		counters: make(map[counterID]map[string]int),
+		path:     path,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var saved []Usage
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, err
+		}
+		for _, u := range saved {
+			s.counters[counterID{Key: u.Key, Period: u.Period, Start: u.Start}] = u.Groups
+		}
+	}
+
+	go func() {
+		for range time.Tick(flushInterval) {
+			if err := s.Flush(); err != nil {
+				log.Printf("Error saving usage counters: %v", err)
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (s *MemoryStore) Consume(ctx context.Context, c Check, now time.Time) (bool, []Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := make([]map[string]int, len(Periods))
+	statuses := make([]Status, len(Periods))
+	allowed := true
+	for i, period := range Periods {
+		id := counterID{Key: c.Key, Period: period, Start: periodStart(period, now)}
+		if groups[i] = s.counters[id]; groups[i] == nil {
+			groups[i] = make(map[string]int)
+			s.counters[id] = groups[i]
+		}
+		total, group := groups[i][Total], groups[i][c.Group]
+		if (c.TotalLimit[i] > 0 && total >= c.TotalLimit[i]) || (c.GroupLimit[i] > 0 && group >= c.GroupLimit[i]) {
+			allowed = false
+		}
+		statuses[i] = status(period, now, c.TotalLimit[i], total, c.GroupLimit[i], group)
+	}
+	if !allowed {
+		return false, statuses, nil
+	}
+
+	for i, period := range Periods {
+		groups[i][Total]++
+		groups[i][c.Group]++
+		statuses[i] = status(period, now, c.TotalLimit[i], groups[i][Total], c.GroupLimit[i], groups[i][c.Group])
+	}
+	s.dirty = true
+	return true, statuses, nil
+}
+
+func (s *MemoryStore) Usage(ctx context.Context, key, period string) ([]Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage(key, period), nil
+}
+
+// usage lists the matching counters, oldest first. The caller holds s.mu.
+func (s *MemoryStore) usage(key, period string) []Usage {
+	list := make([]Usage, 0)
+	for id, groups := range s.counters {
+		if (key != "" && id.Key != key) || (period != "" && id.Period != period) {
+			continue
+		}
+		copied := make(map[string]int, len(groups))
+		for group, n := range groups {
+			copied[group] = n
+		}
+		list = append(list, Usage{Key: id.Key, Period: id.Period, Start: id.Start, Groups: copied})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Start != list[j].Start {
+			return list[i].Start < list[j].Start
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list
+}
+
+// Flush drops expired daily counters and writes the rest to disk.
+func (s *MemoryStore) Flush() error {
+	s.mu.Lock()
+	if !s.dirty || s.path == "" {
+		s.mu.Unlock()
+		return nil
+	}
+	cutoff := time.Now().Add(-dayRetention).UTC().Format(time.DateOnly)
+	for id := range s.counters {
+		if id.Period == Day && id.Start < cutoff {
+			delete(s.counters, id)
+		}
+	}
+	data, err := json.MarshalIndent(s.usage("", ""), "", "  ")
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		// Try again on the next flush.
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
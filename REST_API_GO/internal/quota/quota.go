@@ -0,0 +1,115 @@
+package quota
+
+import (
+	"context"
+	"time"
+)
+
+// Periods that quotas are counted over. Both follow the UTC calendar.
+const (
+	Day   = "day"
+	Month = "month"
+)
+
+// Periods lists every period in the order they are checked.
+var Periods = []string{Day, Month}
+
+// Total is the pseudo group under which a key's requests to all groups are
+// counted.
+const Total = "total"
+
+// Limits caps the requests of one API key per period. A zero limit means
+// unlimited.
+type Limits struct {
+	Day   int `json:"day"`
+	Month int `json:"month"`
+	// Groups caps the requests to individual route groups, e.g. "students".
+	Groups map[string]Limits `json:"groups,omitempty"`
+}
+
+func (l Limits) forPeriod(period string) int {
+	if period == Day {
+		return l.Day
+	}
+	return l.Month
+}
+
+// Check is what Consume compares a request against: for every period, the
+// limit on the key's total and on the request's group.
+type Check struct {
+	Key   string
+	Group string
+	// TotalLimit and GroupLimit are indexed like Periods.
+	TotalLimit []int
+	GroupLimit []int
+}
+
+// NewCheck builds the Check for a request by key to group.
+func NewCheck(key, group string, limits Limits) Check {
+	c := Check{Key: key, Group: group}
+	groupLimits := limits.Groups[group]
+	for _, period := range Periods {
+		c.TotalLimit = append(c.TotalLimit, limits.forPeriod(period))
+		c.GroupLimit = append(c.GroupLimit, groupLimits.forPeriod(period))
+	}
+	return c
+}
+
+// Status is the state of one period's quota after a Consume.
+type Status struct {
+	Period string
+	// Limit and Used describe whichever of the total and group quotas is
+	// closest to being exhausted. Limit is 0 when neither is limited.
+	Limit int
+	Used  int
+	Reset time.Time
+}
+
+func (s Status) Remaining() int {
+	return max(0, s.Limit-s.Used)
+}
+
+// Usage is what a key consumed during one period.
+type Usage struct {
+	Key    string         `json:"key"`
+	Period string         `json:"period"`
+	Start  string         `json:"start"`
+	Groups map[string]int `json:"groups"`
+}
+
+// Store keeps the usage counters.
+type Store interface {
+	// Consume counts one request unless that would exceed a limit of c, in
+	// which case nothing is counted and allowed is false.
+	Consume(ctx context.Context, c Check, now time.Time) (allowed bool, status []Status, err error)
+	// Usage reports the consumption of key, or of every key when key is "",
+	// during the given period.
+	Usage(ctx context.Context, key, period string) ([]Usage, error)
+}
+
+// periodStart names the period containing now, e.g. "2026-10-19" or "2026-10".
+func periodStart(period string, now time.Time) string {
+	now = now.UTC()
+	if period == Day {
+		return now.Format(time.DateOnly)
+	}
+	return now.Format("2006-01")
+}
+
+// periodReset is when the period containing now ends.
+func periodReset(period string, now time.Time) time.Time {
+	now = now.UTC()
+	if period == Day {
+		return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// status picks the tighter of the total and group quota for reporting.
+func status(period string, now time.Time, totalLimit, totalUsed, groupLimit, groupUsed int) Status {
+	s := Status{Period: period, Reset: periodReset(period, now), Limit: totalLimit, Used: totalUsed}
+	if groupLimit > 0 && (totalLimit == 0 || groupLimit-groupUsed < totalLimit-totalUsed) {
+		s.Limit, s.Used = groupLimit, groupUsed
+	}
+	return s
+}
@@ -0,0 +1,143 @@
+package quota
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// stores returns each backend, empty.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	memory, err := NewMemoryStore("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return map[string]Store{"memory": memory, "redis": NewRedisStore(client)}
+}
+
+var limits = Limits{Day: 3, Month: 5, Groups: map[string]Limits{"students": {Day: 2}}}
+
+func TestConsume(t *testing.T) {
+	ctx := context.Background()
+	day := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			consume := func(group string, now time.Time) (bool, []Status) {
+				t.Helper()
+				allowed, statuses, err := s.Consume(ctx, NewCheck("acme", group, limits), now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return allowed, statuses
+			}
+
+			// The group quota is tighter than the total, so it is reported.
+			allowed, statuses := consume("students", day)
+			want := Status{Period: Day, Limit: 2, Used: 1, Reset: time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)}
+			if !allowed || statuses[0] != want {
+				t.Errorf("first request: allowed %v, day %+v, want %+v", allowed, statuses[0], want)
+			}
+			if want := (Status{Period: Month, Limit: 5, Used: 1, Reset: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)}); statuses[1] != want {
+				t.Errorf("first request: month %+v, want %+v", statuses[1], want)
+			}
+			if allowed, _ := consume("students", day); !allowed {
+				t.Error("second student request rejected")
+			}
+			allowed, statuses = consume("students", day)
+			if allowed || statuses[0].Remaining() != 0 {
+				t.Errorf("third student request: allowed %v, day %+v", allowed, statuses[0])
+			}
+
+			// The total still has room for one request to another group.
+			if allowed, statuses := consume("teachers", day); !allowed || statuses[0].Limit != 3 || statuses[0].Used != 3 {
+				t.Errorf("teachers request: allowed %v, day %+v", allowed, statuses[0])
+			}
+			if allowed, _ := consume("teachers", day); allowed {
+				t.Error("request over the daily total allowed")
+			}
+
+			// A new day resets the daily counts but not the monthly ones.
+			next := day.Add(24 * time.Hour)
+			if allowed, statuses := consume("teachers", next); !allowed || statuses[0].Used != 1 || statuses[1].Used != 4 {
+				t.Errorf("next day: allowed %v, %+v", allowed, statuses)
+			}
+			if allowed, _ := consume("teachers", next); !allowed {
+				t.Error("fifth request of the month rejected")
+			}
+			if allowed, statuses := consume("teachers", next); allowed || statuses[1].Remaining() != 0 {
+				t.Errorf("request over the monthly total: allowed %v, %+v", allowed, statuses)
+			}
+
+			// Rejected requests are not counted.
+			usage, err := s.Usage(ctx, "acme", Day)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(usage) != 2 || usage[0].Start != "2026-10-19" || usage[0].Groups[Total] != 3 || usage[0].Groups["students"] != 2 || usage[1].Groups[Total] != 2 {
+				t.Errorf("daily usage = %+v", usage)
+			}
+			usage, err = s.Usage(ctx, "", Month)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(usage) != 1 || usage[0].Start != "2026-10" || usage[0].Groups[Total] != 5 || usage[0].Groups["teachers"] != 3 {
+				t.Errorf("monthly usage = %+v", usage)
+			}
+			if usage, _ := s.Usage(ctx, "other", ""); len(usage) != 0 {
+				t.Errorf("usage of another key = %+v", usage)
+			}
+		})
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	for name, s := range stores(t) {
+		allowed, statuses, err := s.Consume(context.Background(), NewCheck("ops", "students", Limits{}), time.Now())
+		if err != nil || !allowed || statuses[0].Limit != 0 || statuses[0].Used != 1 {
+			t.Errorf("%s: allowed %v, %+v, %v", name, allowed, statuses, err)
+		}
+	}
+}
+
+func TestMemoryStoreFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	s, err := NewMemoryStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old := now.Add(-dayRetention - 48*time.Hour)
+	for _, at := range []time.Time{now, now, old} {
+		if _, _, err := s.Consume(context.Background(), NewCheck("acme", "students", Limits{}), at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewMemoryStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage, err := reopened.Usage(context.Background(), "acme", Day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The expired daily counter was dropped.
+	if len(usage) != 1 || usage[0].Groups["students"] != 2 {
+		t.Errorf("daily usage after reopening = %+v", usage)
+	}
+	if usage, _ := reopened.Usage(context.Background(), "acme", Month); len(usage) != 2 {
+		t.Errorf("monthly usage after reopening = %+v", usage)
+	}
+}
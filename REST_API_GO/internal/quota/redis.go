@@ -0,0 +1,121 @@
+package quota
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps the usage counters in Redis so that every replica counts
+// against the same quota. Each key and period is a hash of per group counts,
+// and quota:index lists the hashes for reporting.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+const redisIndex = "quota:index"
+
+// consumeScript checks and increments the counters of every period
+// atomically. KEYS are the period hashes; ARGV is the group, then per period
+// the total limit, group limit and expiry in seconds (0 for none). It returns
+// 1 or 0 for allowed followed by the total and group count of every period.
+var consumeScript = redis.NewScript(`
+local group = ARGV[1]
+local allowed = 1
+local counts = {}
+for i, key in ipairs(KEYS) do
+	local total = tonumber(redis.call('HGET', key, 'total') or '0')
+	local used = tonumber(redis.call('HGET', key, group) or '0')
+	local totalLimit, groupLimit = tonumber(ARGV[i*3-1]), tonumber(ARGV[i*3])
+	if (totalLimit > 0 and total >= totalLimit) or (groupLimit > 0 and used >= groupLimit) then
+		allowed = 0
+	end
+	counts[i*2-1], counts[i*2] = total, used
+end
+if allowed == 1 then
+	for i, key in ipairs(KEYS) do
+		counts[i*2-1] = redis.call('HINCRBY', key, 'total', 1)
+		counts[i*2] = redis.call('HINCRBY', key, group, 1)
+		local ttl = tonumber(ARGV[i*3+1])
+		if ttl > 0 then
+			redis.call('EXPIRE', key, ttl)
+		end
+		redis.call('SADD', '` + redisIndex + `', key)
+	end
+end
+table.insert(counts, 1, allowed)
+return counts
+`)
+
+func redisKey(key, period, start string) string {
+	return "quota:" + period + ":" + start + ":" + key
+}
+
+func (s *RedisStore) Consume(ctx context.Context, c Check, now time.Time) (bool, []Status, error) {
+	keys := make([]string, len(Periods))
+	args := []any{c.Group}
+	for i, period := range Periods {
+		keys[i] = redisKey(c.Key, period, periodStart(period, now))
+		ttl := 0
+		if period == Day {
+			ttl = int(dayRetention.Seconds())
+		}
+		args = append(args, c.TotalLimit[i], c.GroupLimit[i], ttl)
+	}
+
+	result, err := consumeScript.Run(ctx, s.client, keys, args...).Int64Slice()
+	if err != nil {
+		return false, nil, err
+	}
+	statuses := make([]Status, len(Periods))
+	for i, period := range Periods {
+		total, group := int(result[1+i*2]), int(result[2+i*2])
+		statuses[i] = status(period, now, c.TotalLimit[i], total, c.GroupLimit[i], group)
+	}
+	return result[0] == 1, statuses, nil
+}
+
+func (s *RedisStore) Usage(ctx context.Context, key, period string) ([]Usage, error) {
+	members, err := s.client.SMembers(ctx, redisIndex).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Usage, 0)
+	for _, member := range members {
+		// quota:<period>:<start>:<key>; API key names may contain colons.
+		parts := strings.SplitN(member, ":", 4)
+		if len(parts) != 4 || (period != "" && parts[1] != period) || (key != "" && parts[3] != key) {
+			continue
+		}
+		counts, err := s.client.HGetAll(ctx, member).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(counts) == 0 {
+			// The daily hash expired; forget it.
+			s.client.SRem(ctx, redisIndex, member)
+			continue
+		}
+		u := Usage{Key: parts[3], Period: parts[1], Start: parts[2], Groups: make(map[string]int, len(counts))}
+		for group, n := range counts {
+			u.Groups[group], _ = strconv.Atoi(n)
+		}
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Start != list[j].Start {
+			return list[i].Start < list[j].Start
+		}
+		return list[i].Key < list[j].Key
+	})
+	return list, nil
+}
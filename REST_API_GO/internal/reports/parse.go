@@ -0,0 +1,126 @@
+package reports
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Content types of the report payloads Parse understands.
+const (
+	ContentTypeCSPReport = "application/csp-report"
+	ContentTypeReports   = "application/reports+json"
+)
+
+// ErrUnsupported is returned by Parse for payloads that are not reports.
+var ErrUnsupported = errors.New("unsupported report format")
+
+// legacyCSP is the body of an application/csp-report payload.
+type legacyCSP struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// reportingAPI is one entry of an application/reports+json payload.
+type reportingAPI struct {
+	Type      string          `json:"type"`
+	Age       int64           `json:"age"`
+	URL       string          `json:"url"`
+	UserAgent string          `json:"user_agent"`
+	Body      json.RawMessage `json:"body"`
+}
+
+type cspBody struct {
+	DocumentURL        string `json:"documentURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	BlockedURL         string `json:"blockedURL"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+	Disposition        string `json:"disposition"`
+}
+
+type nelBody struct {
+	Type string `json:"type"`
+}
+
+// Parse normalises a report payload of the given content type, received at
+// now. Reporting API entries other than CSP violations and network errors
+// are skipped.
+func Parse(contentType string, body []byte, now time.Time) ([]Report, error) {
+	switch contentType {
+	case ContentTypeCSPReport, "application/json":
+		var legacy legacyCSP
+		if err := json.Unmarshal(body, &legacy); err != nil {
+			return nil, err
+		}
+		r := legacy.Report
+		directive := r.EffectiveDirective
+		if directive == "" {
+			// Older browsers only send the whole violated directive,
+			// e.g. "script-src 'self'".
+			directive, _, _ = strings.Cut(r.ViolatedDirective, " ")
+		}
+		if directive == "" {
+			return nil, errors.New("csp-report without a directive")
+		}
+		return []Report{{
+			Time:        now,
+			Type:        TypeCSP,
+			DocumentURI: r.DocumentURI,
+			Directive:   directive,
+			BlockedURI:  r.BlockedURI,
+			SourceFile:  r.SourceFile,
+			Line:        r.LineNumber,
+			Disposition: r.Disposition,
+		}}, nil
+
+	case ContentTypeReports:
+		var entries []reportingAPI
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+		reports := make([]Report, 0, len(entries))
+		for _, e := range entries {
+			r := Report{
+				// The age is the client's word; it is report data only.
+				Time:        now.Add(-time.Duration(max(e.Age, 0)) * time.Millisecond),
+				Type:        e.Type,
+				DocumentURI: e.URL,
+				UserAgent:   e.UserAgent,
+			}
+			switch e.Type {
+			case TypeCSP:
+				var b cspBody
+				if err := json.Unmarshal(e.Body, &b); err != nil {
+					return nil, err
+				}
+				r.DocumentURI = b.DocumentURL
+				r.Directive = b.EffectiveDirective
+				r.BlockedURI = b.BlockedURL
+				r.SourceFile = b.SourceFile
+				r.Line = b.LineNumber
+				r.Disposition = b.Disposition
+			case TypeNetworkError:
+				var b nelBody
+				if err := json.Unmarshal(e.Body, &b); err != nil {
+					return nil, err
+				}
+				r.Directive = b.Type
+				r.BlockedURI = e.URL
+			default:
+				continue
+			}
+			reports = append(reports, r)
+		}
+		return reports, nil
+	}
+	return nil, ErrUnsupported
+}
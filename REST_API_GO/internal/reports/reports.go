@@ -0,0 +1,227 @@
+// Package reports collects the violation reports browsers send for the
+// Content-Security-Policy and Network Error Logging policies.
+package reports
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Report types.
+const (
+	TypeCSP          = "csp-violation"
+	TypeNetworkError = "network-error"
+)
+
+// Report is one violation, normalised from either the legacy
+// application/csp-report format or the Reporting API.
+type Report struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	DocumentURI string    `json:"documentUri,omitempty"`
+	// Directive is the violated CSP directive, or the NEL error type such
+	// as "tcp.timed_out".
+	Directive   string `json:"directive"`
+	BlockedURI  string `json:"blockedUri,omitempty"`
+	SourceFile  string `json:"sourceFile,omitempty"`
+	Line        int    `json:"line,omitempty"`
+	Disposition string `json:"disposition,omitempty"`
+	UserAgent   string `json:"userAgent,omitempty"`
+}
+
+// fingerprint identifies reports of the same violation.
+func (r *Report) fingerprint() string {
+	return strings.Join([]string{r.Type, r.DocumentURI, r.Directive, r.BlockedURI, r.SourceFile, fmt.Sprint(r.Line)}, "\x00")
+}
+
+// Count aggregates the reports of one directive and blocked URI.
+type Count struct {
+	Type       string    `json:"type"`
+	Directive  string    `json:"directive"`
+	BlockedURI string    `json:"blockedUri"`
+	Count      int       `json:"count"`
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+// Limits on what clients can make the store hold. The directives and
+// blocked URIs in reports are chosen by whoever sends them.
+const (
+	// maxCounts is how many directive and blocked URI pairs are counted
+	// separately; reports of further pairs are counted under Other.
+	maxCounts = 1000
+	// maxSeen is how many recent reports are remembered for deduplication;
+	// past it, reports are dropped until the window has passed.
+	maxSeen = 100_000
+	// maxFileSize is how large the reports file may grow; past it, reports
+	// are still counted but no longer saved.
+	maxFileSize = 64 << 20
+)
+
+// Other is the directive and blocked URI of the count that aggregates the
+// reports of pairs past the store's limit.
+const Other = "(other)"
+
+// Store keeps the aggregated counts in memory and, when opened with a path,
+// appends every report to a JSON lines file they are rebuilt from.
+type Store struct {
+	mu     sync.Mutex
+	counts map[[3]string]*Count
+	// seen is when each client last sent each violation, for Add's
+	// deduplication. It goes by the server's clock, never the reports'.
+	seen      map[string]time.Time
+	nextSweep time.Time
+	window    time.Duration
+	file      *os.File
+	fileSize  int64
+	now       func() time.Time
+
+	maxCounts   int
+	maxSeen     int
+	maxFileSize int64
+}
+
+// Open loads the reports saved at path and appends new ones to it. An empty
+// path keeps them in memory only. Reports of a violation a client already
+// sent within window are dropped as duplicates.
+func Open(path string, window time.Duration) (*Store, error) {
+	s := &Store{
+		counts:      make(map[[3]string]*Count),
+		seen:        make(map[string]time.Time),
+		window:      window,
+		now:         time.Now,
+		maxCounts:   maxCounts,
+		maxSeen:     maxSeen,
+		maxFileSize: maxFileSize,
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r Report
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("reports %s line %d: %w", path, line, err)
+		}
+		s.count(&r)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s.file, s.fileSize = file, info.Size()
+	return s, nil
+}
+
+// Add records r, sent by client, unless it duplicates one the client sent
+// within the deduplication window. It reports whether r was recorded.
+func (s *Store) Add(client string, r Report) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	key := client + "\x00" + r.fingerprint()
+	if last, ok := s.seen[key]; ok && now.Sub(last) < s.window {
+		return false, nil
+	}
+	if len(s.seen) >= s.maxSeen {
+		return false, nil
+	}
+	s.seen[key] = now
+
+	if s.file != nil && s.fileSize < s.maxFileSize {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return false, err
+		}
+		n, err := s.file.Write(append(line, '\n'))
+		s.fileSize += int64(n)
+		if err != nil {
+			return false, err
+		}
+		if s.fileSize >= s.maxFileSize {
+			slog.Warn("Reports file is full, new reports are only counted", "path", s.file.Name(), "size", s.fileSize)
+		}
+	}
+	s.count(&r)
+	return true, nil
+}
+
+// sweep forgets the clients' reports older than the window, at most once
+// per window.
+func (s *Store) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(s.window)
+	for key, last := range s.seen {
+		if now.Sub(last) >= s.window {
+			delete(s.seen, key)
+		}
+	}
+}
+
+func (s *Store) count(r *Report) {
+	key := [3]string{r.Type, r.Directive, r.BlockedURI}
+	c := s.counts[key]
+	if c == nil && len(s.counts) >= s.maxCounts {
+		key = [3]string{r.Type, Other, Other}
+		c = s.counts[key]
+	}
+	if c == nil {
+		c = &Count{Type: key[0], Directive: key[1], BlockedURI: key[2]}
+		s.counts[key] = c
+	}
+	c.Count++
+	if r.Time.After(c.LastSeen) {
+		c.LastSeen = r.Time
+	}
+}
+
+// Counts returns the aggregated counts, most frequent first.
+func (s *Store) Counts() []Count {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make([]Count, 0, len(s.counts))
+	for _, c := range s.counts {
+		counts = append(counts, *c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		if counts[i].Directive != counts[j].Directive {
+			return counts[i].Directive < counts[j].Directive
+		}
+		return counts[i].BlockedURI < counts[j].BlockedURI
+	})
+	return counts
+}
+
+// Close closes the underlying file.
+func (s *Store) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,186 @@
+package reports
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var received = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func TestParseLegacy(t *testing.T) {
+	body := `{"csp-report":{"document-uri":"https://example.com/","violated-directive":"script-src 'self'","blocked-uri":"https://evil.example/x.js","line-number":7}}`
+	for _, contentType := range []string{ContentTypeCSPReport, "application/json"} {
+		got, err := Parse(contentType, []byte(body), received)
+		if err != nil {
+			t.Fatalf("%s: %v", contentType, err)
+		}
+		want := Report{Time: received, Type: TypeCSP, DocumentURI: "https://example.com/", Directive: "script-src", BlockedURI: "https://evil.example/x.js", Line: 7}
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("%s: got %+v, want %+v", contentType, got, want)
+		}
+	}
+
+	if _, err := Parse(ContentTypeCSPReport, []byte(`{"csp-report":{"blocked-uri":"inline"}}`), received); err == nil {
+		t.Error("report without a directive: no error")
+	}
+	if _, err := Parse("text/plain", []byte(body), received); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("text/plain: err = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestParseReportingAPI(t *testing.T) {
+	body := `[
+		{"type":"csp-violation","age":2000,"url":"https://example.com/","user_agent":"UA","body":{"documentURL":"https://example.com/page","effectiveDirective":"img-src","blockedURL":"https://cdn.example/a.png","disposition":"enforce"}},
+		{"type":"network-error","age":-60000,"url":"https://example.com/api","body":{"type":"tcp.timed_out"}},
+		{"type":"deprecation","age":0,"url":"https://example.com/","body":{}}
+	]`
+	got, err := Parse(ContentTypeReports, []byte(body), received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Report{
+		{Time: received.Add(-2 * time.Second), Type: TypeCSP, DocumentURI: "https://example.com/page", Directive: "img-src", BlockedURI: "https://cdn.example/a.png", Disposition: "enforce", UserAgent: "UA"},
+		// A negative age cannot date a report in the future.
+		{Time: received, Type: TypeNetworkError, DocumentURI: "https://example.com/api", Directive: "tcp.timed_out", BlockedURI: "https://example.com/api"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d reports, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("report %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if _, err := Parse(ContentTypeReports, []byte(`{"type":"csp-violation"}`), received); err == nil {
+		t.Error("a single object instead of a list: no error")
+	}
+}
+
+// newTestStore returns an in-memory store whose clock is *now.
+func newTestStore(t *testing.T, now *time.Time) *Store {
+	t.Helper()
+	s, err := Open("", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func violation(directive, blocked string, at time.Time) Report {
+	return Report{Time: at, Type: TypeCSP, DocumentURI: "https://example.com/", Directive: directive, BlockedURI: blocked}
+}
+
+func TestStoreDeduplicatesByServerTime(t *testing.T) {
+	now := received
+	s := newTestStore(t, &now)
+
+	add := func(client string, r Report) bool {
+		t.Helper()
+		added, err := s.Add(client, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return added
+	}
+	if !add("1.2.3.4", violation("script-src", "inline", now)) {
+		t.Fatal("first report dropped")
+	}
+	// Reports dated far apart by the client are still duplicates.
+	if add("1.2.3.4", violation("script-src", "inline", now.Add(-time.Hour))) {
+		t.Error("report dated an hour earlier was not deduplicated")
+	}
+	if add("1.2.3.4", violation("script-src", "inline", now.Add(time.Hour))) {
+		t.Error("report dated an hour later was not deduplicated")
+	}
+	if !add("5.6.7.8", violation("script-src", "inline", now)) {
+		t.Error("another client's report was deduplicated")
+	}
+
+	now = now.Add(time.Minute)
+	if !add("1.2.3.4", violation("script-src", "inline", now)) {
+		t.Error("report after the window was deduplicated")
+	}
+	if counts := s.Counts(); len(counts) != 1 || counts[0].Count != 3 {
+		t.Errorf("Counts() = %+v, want one count of 3", counts)
+	}
+}
+
+func TestStoreLimits(t *testing.T) {
+	now := received
+	s := newTestStore(t, &now)
+	s.maxCounts, s.maxSeen = 2, 4
+
+	for i := range 3 {
+		if added, err := s.Add("1.2.3.4", violation("script-src", fmt.Sprintf("https://%d.example/", i), now)); !added || err != nil {
+			t.Fatalf("report %d: added %v, %v", i, added, err)
+		}
+	}
+	want := map[string]int{"https://0.example/": 1, "https://1.example/": 1, Other: 1}
+	counts := s.Counts()
+	if len(counts) != len(want) {
+		t.Fatalf("Counts() = %+v, want %v", counts, want)
+	}
+	for _, c := range counts {
+		if want[c.BlockedURI] != c.Count {
+			t.Errorf("%s counted %d times, want %d", c.BlockedURI, c.Count, want[c.BlockedURI])
+		}
+	}
+
+	// Past maxSeen, reports are dropped until the window has passed.
+	if added, _ := s.Add("1.2.3.4", violation("script-src", "https://3.example/", now)); !added {
+		t.Error("fourth report dropped")
+	}
+	if added, _ := s.Add("1.2.3.4", violation("script-src", "https://4.example/", now)); added {
+		t.Error("report past maxSeen added")
+	}
+	now = now.Add(time.Minute)
+	if added, _ := s.Add("1.2.3.4", violation("script-src", "https://4.example/", now)); !added {
+		t.Error("report after the window dropped")
+	}
+}
+
+func TestStoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports.jsonl")
+	s, err := Open(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		if _, err := s.Add("1.2.3.4", violation("img-src", fmt.Sprintf("https://%d.example/", i), received)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Once the file is full, reports are counted but not saved.
+	s.maxFileSize = info.Size()
+	if added, err := s.Add("1.2.3.4", violation("img-src", "https://2.example/", received)); !added || err != nil {
+		t.Fatalf("report past the file size: added %v, %v", added, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Errorf("file grew from %d to %d bytes past its limit", info.Size(), after.Size())
+	}
+	if n := len(s.Counts()); n != 3 {
+		t.Errorf("%d counts before reopening, want 3", n)
+	}
+
+	reopened, err := Open(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if n := len(reopened.Counts()); n != 2 {
+		t.Errorf("%d counts rebuilt from the file, want 2", n)
+	}
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"restapi/internal/models"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendFile   = "file"
+)
+
+// Config selects the store backend shared by the API server and cmd/seed.
+type Config struct {
+	// Backend is BackendMemory or BackendFile.
+	Backend string
+	// Dir is where the file backend keeps one JSON file per collection.
+	Dir string
+}
+
+// ConfigFromEnv reads STORE_BACKEND and DATA_DIR, defaulting to the memory
+// backend and the "data" directory.
+func ConfigFromEnv() Config {
+	c := Config{
+		Backend: os.Getenv("STORE_BACKEND"),
+		Dir:     os.Getenv("DATA_DIR"),
+	}
+	if c.Backend == "" {
+		c.Backend = BackendMemory
+	}
+	if c.Dir == "" {
+		c.Dir = "data"
+	}
+	return c
+}
+
+// Open returns the store for the named collection, e.g. "teachers".
+func Open[T any, P models.Record[T]](c Config, collection string) (Store[T], error) {
+	switch c.Backend {
+	case BackendMemory:
+		return NewMemoryStore[T, P](), nil
+	case BackendFile:
+		if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+			return nil, err
+		}
+		return NewFileStore[T, P](filepath.Join(c.Dir, collection+".json"))
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", c.Backend)
+	}
+}
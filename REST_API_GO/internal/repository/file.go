@@ -0,0 +1,129 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"restapi/internal/models"
+)
+
+// FileStore is a MemoryStore that persists its records to a JSON file after
+// every mutation, so the data survives restarts and can be prepared by
+// cmd/seed before the server starts.
+type FileStore[T any, P models.Record[T]] struct {
+	*MemoryStore[T, P]
+	path   string
+	saveMu sync.Mutex
+}
+
+type snapshot[T any] struct {
+	NextID  int `json:"nextId"`
+	Records []T `json:"records"`
+}
+
+func NewFileStore[T any, P models.Record[T]](path string) (*FileStore[T, P], error) {
+	s := &FileStore[T, P]{
+		MemoryStore: NewMemoryStore[T, P](),
+		path:        path,
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot[T]
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, err
+	}
+	for _, v := range snap.Records {
+		s.records[P(&v).Metadata().ID] = v
+	}
+	s.nextID = max(snap.NextID, 1)
+	return s, nil
+}
+
+func (s *FileStore[T, P]) Create(v T) (T, error) {
+	return s.save(s.MemoryStore.Create(v))
+}
+
+// CreateMany writes the file once for the whole batch.
+func (s *FileStore[T, P]) CreateMany(vs []T) ([]T, error) {
+	created, err := s.MemoryStore.CreateMany(vs)
+	if err != nil {
+		return created, err
+	}
+	return created, s.persist()
+}
+
+func (s *FileStore[T, P]) Update(id, version int, mutate func(*T) error) (T, error) {
+	return s.save(s.MemoryStore.Update(id, version, mutate))
+}
+
+func (s *FileStore[T, P]) Delete(id, version int) (T, error) {
+	return s.save(s.MemoryStore.Delete(id, version))
+}
+
+func (s *FileStore[T, P]) Restore(id int) (T, T, error) {
+	trashed, restored, err := s.MemoryStore.Restore(id)
+	restored, err = s.save(restored, err)
+	return trashed, restored, err
+}
+
+func (s *FileStore[T, P]) Purge(deletedBefore time.Time) []T {
+	purged := s.MemoryStore.Purge(deletedBefore)
+	if len(purged) > 0 {
+		// Purge has no way to report a failed write; the next mutation
+		// writes the purged state again.
+		if err := s.persist(); err != nil {
+			log.Printf("Error saving %s: %v", s.path, err)
+		}
+	}
+	return purged
+}
+
+// save persists the store when the preceding operation succeeded.
+func (s *FileStore[T, P]) save(v T, err error) (T, error) {
+	if err != nil {
+		return v, err
+	}
+	return v, s.persist()
+}
+
+// persist writes the whole store to disk. The snapshot is taken while holding
+// saveMu, so a later state can never be overwritten by an older one.
+func (s *FileStore[T, P]) persist() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	snap := snapshot[T]{NextID: s.nextID, Records: make([]T, 0, len(s.records))}
+	for _, r := range s.records {
+		snap.Records = append(snap.Records, r)
+	}
+	s.mu.Unlock()
+	// Keep the file in ID order so it diffs cleanly.
+	sort.Slice(snap.Records, func(i, j int) bool {
+		return P(&snap.Records[i]).Metadata().ID < P(&snap.Records[j]).Metadata().ID
+	})
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it so a crash never leaves a
+	// truncated store behind.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"restapi/internal/models"
+)
+
+// MemoryStore keeps records in a map guarded by a mutex.
+type MemoryStore[T any, P models.Record[T]] struct {
+	mu      sync.Mutex
+	records map[int]T
+	nextID  int
+}
+
+func NewMemoryStore[T any, P models.Record[T]]() *MemoryStore[T, P] {
+	return &MemoryStore[T, P]{
+		records: make(map[int]T),
+		nextID:  1,
+	}
+}
+
+func (s *MemoryStore[T, P]) List() []T {
+	return s.collect(false)
+}
+
+func (s *MemoryStore[T, P]) Trash() []T {
+	return s.collect(true)
+}
+
+// collect returns either the live or the trashed records, ordered by ID.
+func (s *MemoryStore[T, P]) collect(trashed bool) []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]T, 0, len(s.records))
+	for _, v := range s.records {
+		if (P(&v).Metadata().DeletedAt != nil) == trashed {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return P(&list[i]).Metadata().ID < P(&list[j]).Metadata().ID
+	})
+	return list
+}
+
+func (s *MemoryStore[T, P]) Get(id int) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, exists := s.live(id)
+	if !exists {
+		var zero T
+		return zero, ErrNotFound
+	}
+	return v, nil
+}
+
+func (s *MemoryStore[T, P]) Create(v T) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.create(v), nil
+}
+
+func (s *MemoryStore[T, P]) CreateMany(vs []T) ([]T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	created := make([]T, len(vs))
+	for i, v := range vs {
+		created[i] = s.create(v)
+	}
+	return created, nil
+}
+
+// create stores v under the next ID. The caller must hold s.mu.
+func (s *MemoryStore[T, P]) create(v T) T {
+	meta := P(&v).Metadata()
+	meta.ID = s.nextID
+	meta.Version = 1
+	meta.DeletedAt = nil
+	s.records[meta.ID] = v
+	s.nextID++
+	return v
+}
+
+func (s *MemoryStore[T, P]) Update(id, version int, mutate func(*T) error) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var zero T
+	current, exists := s.live(id)
+	if !exists {
+		return zero, ErrNotFound
+	}
+	currentVersion := P(&current).Metadata().Version
+	if version != 0 && version != currentVersion {
+		return zero, ErrVersionMismatch
+	}
+
+	updated := current
+	if err := mutate(&updated); err != nil {
+		return zero, err
+	}
+	// The mutation must not be able to move, version or trash the record.
+	meta := P(&updated).Metadata()
+	meta.ID = id
+	meta.Version = currentVersion + 1
+	meta.DeletedAt = nil
+	s.records[id] = updated
+	return updated, nil
+}
+
+func (s *MemoryStore[T, P]) Delete(id, version int) (T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var zero T
+	current, exists := s.live(id)
+	if !exists {
+		return zero, ErrNotFound
+	}
+	meta := P(&current).Metadata()
+	if version != 0 && version != meta.Version {
+		return zero, ErrVersionMismatch
+	}
+	now := time.Now()
+	meta.Version++
+	meta.DeletedAt = &now
+	s.records[id] = current
+	return current, nil
+}
+
+func (s *MemoryStore[T, P]) Restore(id int) (T, T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trashed, exists := s.records[id]
+	if !exists || P(&trashed).Metadata().DeletedAt == nil {
+		var zero T
+		return zero, zero, ErrNotFound
+	}
+	restored := trashed
+	meta := P(&restored).Metadata()
+	meta.Version++
+	meta.DeletedAt = nil
+	s.records[id] = restored
+	return trashed, restored, nil
+}
+
+func (s *MemoryStore[T, P]) Purge(deletedBefore time.Time) []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged []T
+	for id, v := range s.records {
+		deletedAt := P(&v).Metadata().DeletedAt
+		if deletedAt != nil && deletedAt.Before(deletedBefore) {
+			delete(s.records, id)
+			purged = append(purged, v)
+		}
+	}
+	return purged
+}
+
+// live returns the record with the given ID unless it is in the trash.
+// The caller must hold s.mu.
+func (s *MemoryStore[T, P]) live(id int) (T, bool) {
+	v, exists := s.records[id]
+	if !exists || P(&v).Metadata().DeletedAt != nil {
+		var zero T
+		return zero, false
+	}
+	return v, true
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"log"
+	"time"
+)
+
+// Purger permanently removes trashed records, returning how many it removed.
+// Stores are wrapped by something that also records what was removed, such
+// as the API handlers, which audit every purged record.
+type Purger interface {
+	Purge(deletedBefore time.Time) int
+}
+
+// SchedulePurge starts a background job that, every interval, permanently
+// removes records that have been in the trash for longer than retention.
+// Closing the returned channel stops the job. An interval of 0 or less
+// disables purging, so trashed records are kept until restored.
+func SchedulePurge(retention, interval time.Duration, stores map[string]Purger) chan<- struct{} {
+	done := make(chan struct{})
+	if interval <= 0 {
+		log.Println("Trash purging is disabled")
+		return done
+	}
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				for name, store := range stores {
+					if n := store.Purge(now.Add(-retention)); n > 0 {
+						log.Printf("Purged %d %s from the trash", n, name)
+					}
+				}
+			}
+		}
+	}()
+	return done
+}
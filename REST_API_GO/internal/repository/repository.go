@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound        = errors.New("record not found")
+	ErrVersionMismatch = errors.New("record version mismatch")
+)
+
+// Store is the persistence contract used by the API handlers.
+//
+// Update and Delete take the version the caller expects the record to be at;
+// a version of 0 skips the check. Delete only moves a record to the trash:
+// trashed records are invisible to List, Get and Update until they are
+// restored, and are removed for good by Purge.
+type Store[T any] interface {
+	List() []T
+	Get(id int) (T, error)
+	Create(v T) (T, error)
+	// CreateMany creates every record in vs in one go, which is much
+	// cheaper than one Create per record for stores that persist.
+	CreateMany(vs []T) ([]T, error)
+	Update(id, version int, mutate func(*T) error) (T, error)
+	Delete(id, version int) (T, error)
+
+	Trash() []T
+	// Restore returns the record both as it was in the trash and as
+	// restored, so the two cannot belong to different changes.
+	Restore(id int) (trashed, restored T, err error)
+	// Purge returns the records it removed.
+	Purge(deletedBefore time.Time) []T
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"restapi/internal/models"
+)
+
+func teacher(firstName string) models.Teacher {
+	return models.Teacher{FirstName: firstName, LastName: "Lovelace", Class: "9A", Subject: "Math"}
+}
+
+// stores returns each backend, empty.
+func stores(t *testing.T) map[string]Store[models.Teacher] {
+	t.Helper()
+	file, err := NewFileStore[models.Teacher](filepath.Join(t.TempDir(), "teachers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store[models.Teacher]{
+		"memory": NewMemoryStore[models.Teacher](),
+		"file":   file,
+	}
+}
+
+func ids(list []models.Teacher) []int {
+	result := make([]int, len(list))
+	for i, v := range list {
+		result[i] = v.ID
+	}
+	return result
+}
+
+func TestSoftDelete(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			created, err := s.CreateMany([]models.Teacher{teacher("Ada"), teacher("Grace"), teacher("Hedy")})
+			if err != nil || len(created) != 3 || created[2].ID != 3 || created[2].Version != 1 {
+				t.Fatalf("CreateMany = %+v, %v", created, err)
+			}
+
+			if _, err := s.Delete(2, 7); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("Delete of the wrong version: %v", err)
+			}
+			deleted, err := s.Delete(2, 1)
+			if err != nil || deleted.Version != 2 || deleted.DeletedAt == nil {
+				t.Fatalf("Delete = %+v, %v", deleted.Meta, err)
+			}
+			if got := ids(s.List()); len(got) != 2 || got[0] != 1 || got[1] != 3 {
+				t.Errorf("List after Delete = %v", got)
+			}
+			if got := ids(s.Trash()); len(got) != 1 || got[0] != 2 {
+				t.Errorf("Trash = %v", got)
+			}
+
+			// A trashed record is gone for everything but Restore and Purge.
+			if _, err := s.Get(2); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get of a trashed record: %v", err)
+			}
+			if _, err := s.Update(2, 0, func(*models.Teacher) error { return nil }); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Update of a trashed record: %v", err)
+			}
+			if _, err := s.Delete(2, 0); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Delete of a trashed record: %v", err)
+			}
+
+			trashed, restored, err := s.Restore(2)
+			if err != nil || trashed.Version != 2 || trashed.DeletedAt == nil || restored.Version != 3 || restored.DeletedAt != nil {
+				t.Fatalf("Restore = %+v, %+v, %v", trashed.Meta, restored.Meta, err)
+			}
+			if _, _, err := s.Restore(2); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Restore of a live record: %v", err)
+			}
+			if _, _, err := s.Restore(9); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Restore of a missing record: %v", err)
+			}
+			if got, err := s.Get(2); err != nil || got.FirstName != "Grace" {
+				t.Errorf("Get after Restore = %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, firstName := range []string{"Ada", "Grace", "Hedy"} {
+				if _, err := s.Create(teacher(firstName)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, id := range []int{1, 2} {
+				if _, err := s.Delete(id, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Records trashed after the cutoff are kept.
+			if purged := s.Purge(time.Now().Add(-time.Hour)); len(purged) != 0 {
+				t.Errorf("Purge of older records removed %v", ids(purged))
+			}
+			purged := s.Purge(time.Now().Add(time.Second))
+			if len(purged) != 2 {
+				t.Errorf("Purge removed %v, want 1 and 2", ids(purged))
+			}
+			if trash := s.Trash(); len(trash) != 0 {
+				t.Errorf("Trash after Purge = %v", ids(trash))
+			}
+			if _, _, err := s.Restore(1); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Restore of a purged record: %v", err)
+			}
+			if got := ids(s.List()); len(got) != 1 || got[0] != 3 {
+				t.Errorf("List after Purge = %v", got)
+			}
+
+			// IDs are not reused.
+			if created, err := s.Create(teacher("Mary")); err != nil || created.ID != 4 {
+				t.Errorf("Create after Purge = %+v, %v", created.Meta, err)
+			}
+		})
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "teachers.json")
+	s, err := NewFileStore[models.Teacher](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, firstName := range []string{"Ada", "Grace", "Hedy"} {
+		if _, err := s.Create(teacher(firstName)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Delete(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Delete(3, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.Purge(time.Now().Add(time.Second))
+	if _, err := s.Delete(2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewFileStore[models.Teacher](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(reopened.Trash()); len(got) != 1 || got[0] != 2 {
+		t.Errorf("Trash after reopening = %v", got)
+	}
+	if created, err := reopened.Create(teacher("Mary")); err != nil || created.ID != 4 {
+		t.Errorf("Create after reopening = %+v, %v", created.Meta, err)
+	}
+}
+
+func TestSchedulePurge(t *testing.T) {
+	s := NewMemoryStore[models.Teacher]()
+	if _, err := s.Create(teacher("Ada")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Delete(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	purged := make(chan int, 1)
+	done := SchedulePurge(0, 10*time.Millisecond, map[string]Purger{"teachers": purgerFunc(func(before time.Time) int {
+		n := len(s.Purge(before))
+		if n > 0 {
+			purged <- n
+		}
+		return n
+	})})
+	defer close(done)
+
+	select {
+	case n := <-purged:
+		if n != 1 {
+			t.Errorf("purged %d records, want 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing purged")
+	}
+}
+
+type purgerFunc func(deletedBefore time.Time) int
+
+func (f purgerFunc) Purge(deletedBefore time.Time) int {
+	return f(deletedBefore)
+}
@@ -0,0 +1,54 @@
+package seed
+
+import (
+	"fmt"
+	"strings"
+
+	"restapi/internal/models"
+)
+
+var (
+	firstNames = []string{"Olivia", "Liam", "Emma", "Noah", "Ava", "Elijah", "Sophia", "James", "Isabella", "Lucas"}
+	lastNames  = []string{"Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis", "Martinez", "Lopez", "Wilson"}
+	classes    = []string{"9A", "9B", "10A", "10B", "11A", "11B", "12A", "12B"}
+	subjects   = []string{"Math", "Algebra", "Physics", "Chemistry", "Biology", "History", "English", "Geography"}
+)
+
+// Generate creates n synthetic teachers and n synthetic students. The output
+// only depends on n, so generating the same dataset twice yields the same
+// natural keys and Apply stays idempotent.
+func Generate(n int) Dataset {
+	ds := Dataset{
+		Teachers: make([]models.Teacher, 0, n),
+		Students: make([]models.Student, 0, n),
+	}
+	for i := 0; i < n; i++ {
+		first, last := syntheticName(i)
+		ds.Teachers = append(ds.Teachers, models.Teacher{
+			FirstName: first,
+			LastName:  last,
+			Class:     classes[i%len(classes)],
+			Subject:   subjects[i%len(subjects)],
+		})
+		// Offset the students so they don't share names with the teachers.
+		first, last = syntheticName(i + len(firstNames)/2)
+		ds.Students = append(ds.Students, models.Student{
+			FirstName: first,
+			LastName:  last,
+			Email:     fmt.Sprintf("%s.%s.%d@school.example", strings.ToLower(first), strings.ToLower(last), i+1),
+			Class:     classes[i%len(classes)],
+		})
+	}
+	return ds
+}
+
+// syntheticName walks every first and last name combination, then starts
+// numbering the last names so names never repeat.
+func syntheticName(i int) (string, string) {
+	first := firstNames[i%len(firstNames)]
+	last := lastNames[(i/len(firstNames))%len(lastNames)]
+	if round := i / (len(firstNames) * len(lastNames)); round > 0 {
+		last = fmt.Sprintf("%s%d", last, round+1)
+	}
+	return first, last
+}
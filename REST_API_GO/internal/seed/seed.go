@@ -0,0 +1,153 @@
+package seed
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"restapi/internal/models"
+	"restapi/internal/repository"
+)
+
+//go:embed fixtures
+var fixtures embed.FS
+
+// Dataset is a set of records to load into the stores.
+type Dataset struct {
+	Teachers []models.Teacher
+	Students []models.Student
+}
+
+// Named returns a built-in dataset. "loadtest" is generated with n teachers
+// and n students; every other name is looked up in the embedded fixtures.
+func Named(name string, n int) (Dataset, error) {
+	if name == "loadtest" {
+		return Generate(n), nil
+	}
+	sub, err := fs.Sub(fixtures, path.Join("fixtures", name))
+	if err != nil {
+		return Dataset{}, err
+	}
+	if _, err := fs.Stat(sub, "."); err != nil {
+		return Dataset{}, fmt.Errorf("unknown dataset %q", name)
+	}
+	return Load(sub)
+}
+
+// LoadDir reads a dataset from fixture files in dir.
+func LoadDir(dir string) (Dataset, error) {
+	return Load(os.DirFS(dir))
+}
+
+// Load reads teachers and students fixtures from fsys. Each collection may be
+// stored as .json, .yaml or .yml and may be missing altogether.
+func Load(fsys fs.FS) (Dataset, error) {
+	var ds Dataset
+	if err := readFixture(fsys, "teachers", &ds.Teachers); err != nil {
+		return Dataset{}, err
+	}
+	if err := readFixture(fsys, "students", &ds.Students); err != nil {
+		return Dataset{}, err
+	}
+	return ds, nil
+}
+
+func readFixture(fsys fs.FS, collection string, out any) error {
+	for _, ext := range []string{".json", ".yaml", ".yml"} {
+		name := collection + ext
+		data, err := fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if ext != ".json" {
+			if data, err = yamlToJSON(data); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// yamlToJSON converts YAML fixtures to JSON so that both formats are decoded
+// through the models' json tags.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// Result counts what Apply did for one collection.
+type Result struct {
+	Created int
+	Skipped int
+}
+
+// Apply loads ds into the stores. It is idempotent: a record whose natural
+// key is already present, live or in the trash, is skipped.
+func Apply(ds Dataset, teachers repository.Store[models.Teacher], students repository.Store[models.Student]) (map[string]Result, error) {
+	results := make(map[string]Result)
+	var err error
+	if results["teachers"], err = apply(teachers, ds.Teachers, TeacherKey); err != nil {
+		return results, err
+	}
+	if results["students"], err = apply(students, ds.Students, StudentKey); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+func apply[T any](store repository.Store[T], records []T, key func(T) string) (Result, error) {
+	var result Result
+	existing := make(map[string]bool)
+	for _, v := range append(store.List(), store.Trash()...) {
+		existing[key(v)] = true
+	}
+	var missing []T
+	for _, v := range records {
+		k := key(v)
+		if existing[k] {
+			result.Skipped++
+			continue
+		}
+		missing = append(missing, v)
+		existing[k] = true
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+	// One batch, so a file store is written once rather than per record.
+	if _, err := store.CreateMany(missing); err != nil {
+		return result, err
+	}
+	result.Created = len(missing)
+	return result, nil
+}
+
+// TeacherKey identifies a teacher by name.
+func TeacherKey(t models.Teacher) string {
+	return strings.ToLower(t.FirstName + " " + t.LastName)
+}
+
+// StudentKey identifies a student by email, or by name when it has none.
+func StudentKey(s models.Student) string {
+	if s.Email != "" {
+		return strings.ToLower(s.Email)
+	}
+	return strings.ToLower(s.FirstName + " " + s.LastName)
+}
@@ -0,0 +1,105 @@
+package seed
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"restapi/internal/models"
+	"restapi/internal/repository"
+)
+
+func TestNamed(t *testing.T) {
+	ds, err := Named("demo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.Teachers) == 0 || len(ds.Students) == 0 {
+		t.Errorf("demo has %d teachers and %d students", len(ds.Teachers), len(ds.Students))
+	}
+	for _, v := range ds.Teachers {
+		if err := v.Validate(); err != nil {
+			t.Errorf("demo teacher %+v: %v", v, err)
+		}
+	}
+	for _, v := range ds.Students {
+		if err := v.Validate(); err != nil {
+			t.Errorf("demo student %+v: %v", v, err)
+		}
+	}
+
+	if _, err := Named("missing", 0); err == nil {
+		t.Error("unknown dataset: no error")
+	}
+	if ds, err := Named("loadtest", 250); err != nil || len(ds.Teachers) != 250 || len(ds.Students) != 250 {
+		t.Errorf("loadtest: %d teachers, %d students, %v", len(ds.Teachers), len(ds.Students), err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ds, err := Load(fstest.MapFS{
+		"teachers.yml": {Data: []byte("- firstName: Ada\n  lastName: Lovelace\n  class: 9A\n  subject: Math\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.Teacher{FirstName: "Ada", LastName: "Lovelace", Class: "9A", Subject: "Math"}
+	if len(ds.Teachers) != 1 || ds.Teachers[0] != want || ds.Students != nil {
+		t.Errorf("Load = %+v", ds)
+	}
+
+	if _, err := Load(fstest.MapFS{"students.json": {Data: []byte(`{"not":"a list"}`)}}); err == nil {
+		t.Error("invalid fixture: no error")
+	}
+}
+
+func TestGenerateIsUnique(t *testing.T) {
+	ds := Generate(1000)
+	teachers := make(map[string]bool)
+	for _, v := range ds.Teachers {
+		if teachers[TeacherKey(v)] {
+			t.Fatalf("teacher %q generated twice", TeacherKey(v))
+		}
+		teachers[TeacherKey(v)] = true
+	}
+	students := make(map[string]bool)
+	for _, v := range ds.Students {
+		if students[StudentKey(v)] {
+			t.Fatalf("student %q generated twice", StudentKey(v))
+		}
+		students[StudentKey(v)] = true
+	}
+}
+
+func TestApplyIsIdempotent(t *testing.T) {
+	teachers := repository.NewMemoryStore[models.Teacher]()
+	students := repository.NewMemoryStore[models.Student]()
+	ds := Generate(20)
+	// Duplicates within the dataset are only created once.
+	ds.Teachers = append(ds.Teachers, ds.Teachers[0])
+
+	results, err := Apply(ds, teachers, students)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results["teachers"] != (Result{Created: 20, Skipped: 1}) || results["students"] != (Result{Created: 20}) {
+		t.Errorf("first Apply = %+v", results)
+	}
+
+	// Trashed records count as present, and keys ignore case.
+	if _, err := teachers.Delete(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	ds.Students[0].Email = strings.ToUpper(ds.Students[0].Email)
+	ds.Teachers[1].FirstName = strings.ToUpper(ds.Teachers[1].FirstName)
+	results, err = Apply(ds, teachers, students)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results["teachers"] != (Result{Skipped: 21}) || results["students"] != (Result{Skipped: 20}) {
+		t.Errorf("second Apply = %+v", results)
+	}
+	if n := len(teachers.List()) + len(teachers.Trash()); n != 20 {
+		t.Errorf("%d teachers stored, want 20", n)
+	}
+}